@@ -1,12 +1,17 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"fmt"
-	"github.com/mattkimber/cargopositor/internal/compositor"
+	"github.com/mattkimber/cargopositor/compositor"
 	"log"
 	"os"
+	"os/signal"
 	"runtime/pprof"
+	"sort"
+	"strings"
+	"syscall"
 	"time"
 )
 
@@ -15,6 +20,8 @@ type Flags struct {
 	VoxelDirectory  string
 	OutputTime      bool
 	ProfileFile     string
+	Check           bool
+	Ramps           bool
 }
 
 var flags Flags
@@ -25,6 +32,8 @@ func init() {
 	flag.StringVar(&flags.VoxelDirectory, "voxel_dir", "", "root directory for input voxel objects (default to the current path)")
 	flag.BoolVar(&flags.OutputTime, "time", false, "output basic profiling information")
 	flag.StringVar(&flags.ProfileFile, "profile", "", "output Go profiling information to the specified file")
+	flag.BoolVar(&flags.Check, "check", false, "check outputs are up to date without writing anything")
+	flag.BoolVar(&flags.Ramps, "ramps", false, "list the named ramps in each batch and the operations using them")
 
 	// Short format
 	flag.StringVar(&flags.OutputDirectory, "o", "", "shorthand for -output_dir")
@@ -49,7 +58,7 @@ func main() {
 		defer pprof.StopCPUProfile()
 	}
 
-	if flags.OutputDirectory != "" {
+	if flags.OutputDirectory != "" && !flags.Check {
 		if _, err := os.Stat(flags.OutputDirectory); os.IsNotExist(err) {
 			if err := os.Mkdir(flags.OutputDirectory, 0755); err != nil {
 				panic(err)
@@ -57,12 +66,40 @@ func main() {
 		}
 	}
 
+	// Stop cleanly at the next operation on Ctrl-C or SIGTERM. A second
+	// signal will terminate immediately.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	if flags.Ramps {
+		listRamps()
+		return
+	}
+
+	if flags.Check {
+		if !check(ctx) {
+			os.Exit(1)
+		}
+		return
+	}
+
 	for _, batchFile := range flag.Args() {
 		batch, err := compositor.FromFile(batchFile)
 		if err != nil {
 			log.Fatalf("could not load batch %s: %v", batchFile, err)
 		} else {
-			if err := batch.Run(flags.OutputDirectory, flags.VoxelDirectory); err != nil {
+			summary, err := batch.Run(ctx, flags.OutputDirectory, flags.VoxelDirectory)
+			reportWarnings(summary)
+
+			if err != nil {
+				if ctx.Err() != nil {
+					log.Fatalf("interrupted while executing batch %s: %v", batchFile, err)
+				}
 				log.Fatalf("could not execute batch %s: %v", batchFile, err)
 			}
 		}
@@ -72,3 +109,79 @@ func main() {
 		fmt.Printf("Total time: %dms\n", time.Since(start).Milliseconds())
 	}
 }
+
+// listRamps prints each named ramp in every batch along with the operations
+// which use it
+func listRamps() {
+	for _, batchFile := range flag.Args() {
+		b, err := compositor.FromFile(batchFile)
+		if err != nil {
+			log.Fatalf("could not load batch %s: %v", batchFile, err)
+		}
+
+		fmt.Printf("%s:\n", batchFile)
+
+		usage := b.RampUsage()
+		names := make([]string, 0, len(usage))
+		for name := range usage {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			ramp, ok := b.Ramps[name]
+			if !ok {
+				ramp = "(undefined)"
+			}
+
+			ops := "(unused)"
+			if len(usage[name]) > 0 {
+				ops = strings.Join(usage[name], ", ")
+			}
+
+			fmt.Printf("  @%s = %s: %s\n", name, ramp, ops)
+		}
+	}
+}
+
+// check verifies the outputs of every batch, returning false if any are
+// missing or out of date
+func check(ctx context.Context) bool {
+	current := true
+
+	for _, batchFile := range flag.Args() {
+		batch, err := compositor.FromFile(batchFile)
+		if err != nil {
+			log.Fatalf("could not load batch %s: %v", batchFile, err)
+		}
+
+		results, summary, err := batch.Check(ctx, flags.OutputDirectory, flags.VoxelDirectory)
+		reportWarnings(summary)
+		if err != nil {
+			log.Fatalf("could not check batch %s: %v", batchFile, err)
+		}
+
+		for _, result := range results {
+			// Skipped outputs are reported as warnings, as they are by a
+			// normal run
+			if result.Status != compositor.CheckCurrent && result.Status != compositor.CheckSkipped {
+				fmt.Printf("%s: %s\n", result.Status, result.Filename)
+				current = false
+			}
+		}
+	}
+
+	return current
+}
+
+// reportWarnings logs the inputs which had no mask voxels, and the outputs
+// which were skipped because of it
+func reportWarnings(summary compositor.Summary) {
+	for _, nm := range summary.NoMask {
+		log.Printf("WARNING: %s has no mask voxels for operation %s (policy: %s)", nm.Input, nm.Operation, nm.Policy)
+	}
+
+	for _, filename := range summary.Skipped {
+		log.Printf("WARNING: skipped %s", filename)
+	}
+}
@@ -0,0 +1,427 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+)
+
+// Matrix is a 3x4 affine transform. Each row gives one output co-ordinate
+// as a combination of the input X, Y and Z co-ordinates plus a constant.
+// Co-ordinates are continuous, with voxel (0,0,0) covering 0-1 on each axis.
+type Matrix [3][4]float64
+
+// IdentityMatrix returns a transform which leaves objects unchanged
+func IdentityMatrix() Matrix {
+	return Matrix{{1, 0, 0, 0}, {0, 1, 0, 0}, {0, 0, 1, 0}}
+}
+
+// TranslationMatrix returns a transform which moves objects by t
+func TranslationMatrix(t geometry.PointF) Matrix {
+	return Matrix{{1, 0, 0, t.X}, {0, 1, 0, t.Y}, {0, 0, 1, t.Z}}
+}
+
+// ScaleMatrix returns a transform which scales objects by s around the
+// origin
+func ScaleMatrix(s geometry.PointF) Matrix {
+	return Matrix{{s.X, 0, 0, 0}, {0, s.Y, 0, 0}, {0, 0, s.Z, 0}}
+}
+
+// RotationMatrix returns a transform which rotates objects by angle degrees
+// around an axis through the origin. Positive angles follow the right-hand
+// rule.
+func RotationMatrix(axis geometry.PointF, angle float64) (Matrix, error) {
+	length := math.Sqrt(axis.X*axis.X + axis.Y*axis.Y + axis.Z*axis.Z)
+	if length == 0 || math.IsNaN(length) || math.IsInf(length, 0) {
+		return Matrix{}, fmt.Errorf("rotation axis %v is not valid", axis)
+	}
+
+	x, y, z := axis.X/length, axis.Y/length, axis.Z/length
+	sin, cos := math.Sin(degToRad(angle)), math.Cos(degToRad(angle))
+	t := 1 - cos
+
+	return Matrix{
+		{t*x*x + cos, t*x*y - sin*z, t*x*z + sin*y, 0},
+		{t*x*y + sin*z, t*y*y + cos, t*y*z - sin*x, 0},
+		{t*x*z - sin*y, t*y*z + sin*x, t*z*z + cos, 0},
+	}, nil
+}
+
+// EulerMatrix returns a transform which rotates objects around the X, then
+// Y, then Z axes through the origin by the given number of degrees
+func EulerMatrix(angles geometry.PointF) Matrix {
+	rx, _ := RotationMatrix(geometry.PointF{X: 1}, angles.X)
+	ry, _ := RotationMatrix(geometry.PointF{Y: 1}, angles.Y)
+	rz, _ := RotationMatrix(geometry.PointF{Z: 1}, angles.Z)
+	return rx.Then(ry).Then(rz)
+}
+
+// Then returns a transform which applies m followed by n
+func (m Matrix) Then(n Matrix) (r Matrix) {
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 4; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += n[i][k] * m[k][j]
+			}
+		}
+		r[i][3] += n[i][3]
+	}
+	return r
+}
+
+// Apply transforms a point
+func (m Matrix) Apply(p geometry.PointF) geometry.PointF {
+	return geometry.PointF{
+		X: m[0][0]*p.X + m[0][1]*p.Y + m[0][2]*p.Z + m[0][3],
+		Y: m[1][0]*p.X + m[1][1]*p.Y + m[1][2]*p.Z + m[1][3],
+		Z: m[2][0]*p.X + m[2][1]*p.Y + m[2][2]*p.Z + m[2][3],
+	}
+}
+
+// Inverse returns the transform which undoes m
+func (m Matrix) Inverse() (r Matrix, err error) {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+
+	if math.Abs(det) < 1e-12 || math.IsNaN(det) {
+		return r, fmt.Errorf("transform cannot be inverted")
+	}
+
+	r[0][0] = (m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det
+	r[0][1] = (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det
+	r[0][2] = (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det
+	r[1][0] = (m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det
+	r[1][1] = (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det
+	r[1][2] = (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det
+	r[2][0] = (m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det
+	r[2][1] = (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det
+	r[2][2] = (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det
+
+	for i := 0; i < 3; i++ {
+		r[i][3] = -(r[i][0]*m[0][3] + r[i][1]*m[1][3] + r[i][2]*m[2][3])
+	}
+
+	return r, nil
+}
+
+// Resampling selects how output voxels are sampled from the input when
+// transforming an object
+type Resampling string
+
+const (
+	// ResampleNearest uses the input voxel at the centre of each output voxel
+	ResampleNearest Resampling = "nearest"
+	// ResampleModal takes several samples within each output voxel and uses
+	// the most common colour. Empty space only wins if it is strictly more
+	// common than every colour, which avoids holes along diagonal edges.
+	ResampleModal Resampling = "modal"
+)
+
+func (r Resampling) validate() error {
+	switch r {
+	case "", ResampleNearest, ResampleModal:
+		return nil
+	}
+
+	return fmt.Errorf("unknown resampling %s", r)
+}
+
+// defaultSamples is the number of samples per axis for modal resampling
+const defaultSamples = 3
+
+// maxTransformSize is the largest output a transform may produce on any
+// axis, to stop a mistyped scale from exhausting memory
+const maxTransformSize = 4096
+
+// TransformOptions controls how Transform resamples an object
+type TransformOptions struct {
+	// Resampling selects the resampling method. The default is
+	// ResampleNearest.
+	Resampling Resampling
+
+	// Samples is the number of samples per axis for ResampleModal. 0 uses
+	// a default of 3.
+	Samples int
+
+	// KeepSize keeps the input canvas, clipping anything transformed
+	// outside it. By default the canvas is fitted to the transformed
+	// object, and any translation of the whole object is lost.
+	KeepSize bool
+}
+
+// Transform applies an affine transform to an object, sampling each output
+// voxel from the input in a single pass
+func Transform(ctx context.Context, v magica.VoxelObject, m Matrix, opts TransformOptions) (r magica.VoxelObject, err error) {
+	if err := opts.Resampling.validate(); err != nil {
+		return v.Copy(), err
+	}
+
+	samples := 1
+	if opts.Resampling == ResampleModal {
+		samples = opts.Samples
+		if samples == 0 {
+			samples = defaultSamples
+		}
+		if samples < 1 {
+			return v.Copy(), fmt.Errorf("samples %d must be at least 1", samples)
+		}
+	}
+
+	inverse, err := m.Inverse()
+	if err != nil {
+		return v.Copy(), err
+	}
+
+	size, origin := v.Size, geometry.PointF{}
+	if !opts.KeepSize {
+		size, origin, err = transformedBounds(v.Size, m)
+		if err != nil {
+			return v.Copy(), err
+		}
+	}
+
+	return resample(ctx, &v, size, origin, samples, inverse.Apply)
+}
+
+// resample produces an object of the given size by mapping sample points
+// within each output voxel back to the input. origin is the position of the
+// output's (0,0,0) corner in output co-ordinates. With more than one sample
+// per axis the most common colour is used, and empty space only wins if it
+// is strictly more common than every colour.
+func resample(ctx context.Context, v *magica.VoxelObject, size geometry.Point, origin geometry.PointF, samples int, inverse func(geometry.PointF) geometry.PointF) (r magica.VoxelObject, err error) {
+	r = magica.NewVoxelObject(size, v.PaletteData)
+	step := 1 / float64(samples)
+
+	err = iterate(ctx, &r, func(x, y, z int) {
+		var counts colourCounts
+		empty := 0
+
+		for sx := 0; sx < samples; sx++ {
+			for sy := 0; sy < samples; sy++ {
+				for sz := 0; sz < samples; sz++ {
+					p := inverse(geometry.PointF{
+						X: origin.X + float64(x) + (float64(sx)+0.5)*step,
+						Y: origin.Y + float64(y) + (float64(sy)+0.5)*step,
+						Z: origin.Z + float64(z) + (float64(sz)+0.5)*step,
+					})
+
+					c := sample(v, p)
+					if c == 0 {
+						empty++
+					}
+					counts[c]++
+				}
+			}
+		}
+
+		best := counts.pick(FilterModal, nil, nil)
+		if counts[best] >= empty {
+			r.Voxels[x][y][z] = best
+		}
+	})
+
+	return r, err
+}
+
+// sample returns the colour of the input voxel containing p, or 0 if p is
+// outside the object
+func sample(v *magica.VoxelObject, p geometry.PointF) byte {
+	x, y, z := math.Floor(p.X), math.Floor(p.Y), math.Floor(p.Z)
+	if x < 0 || y < 0 || z < 0 || x >= float64(v.Size.X) || y >= float64(v.Size.Y) || z >= float64(v.Size.Z) {
+		return 0
+	}
+	return v.Voxels[int(x)][int(y)][int(z)]
+}
+
+// transformedBounds returns the size and minimum corner of the box which
+// contains an object of the given size after transforming
+func transformedBounds(size geometry.Point, m Matrix) (geometry.Point, geometry.PointF, error) {
+	min := geometry.PointF{X: math.Inf(1), Y: math.Inf(1), Z: math.Inf(1)}
+	max := geometry.PointF{X: math.Inf(-1), Y: math.Inf(-1), Z: math.Inf(-1)}
+
+	for _, x := range []int{0, size.X} {
+		for _, y := range []int{0, size.Y} {
+			for _, z := range []int{0, size.Z} {
+				p := m.Apply(geometry.PointF{X: float64(x), Y: float64(y), Z: float64(z)})
+				min = geometry.PointF{X: math.Min(min.X, p.X), Y: math.Min(min.Y, p.Y), Z: math.Min(min.Z, p.Z)}
+				max = geometry.PointF{X: math.Max(max.X, p.X), Y: math.Max(max.Y, p.Y), Z: math.Max(max.Z, p.Z)}
+			}
+		}
+	}
+
+	// Allow for floating point error, so that exact transforms such as
+	// quarter turns don't gain an extra layer of voxels
+	const epsilon = 1e-9
+	extent := func(lo, hi float64) int { return int(math.Ceil(hi - lo - epsilon)) }
+	origin := geometry.PointF{X: math.Round(min.X/epsilon) * epsilon, Y: math.Round(min.Y/epsilon) * epsilon, Z: math.Round(min.Z/epsilon) * epsilon}
+	result := geometry.Point{X: extent(min.X, max.X), Y: extent(min.Y, max.Y), Z: extent(min.Z, max.Z)}
+
+	for _, s := range []int{result.X, result.Y, result.Z} {
+		if s > maxTransformSize || s < 0 {
+			return result, origin, fmt.Errorf("transformed object would be %d voxels across, which is more than %d", s, maxTransformSize)
+		}
+	}
+
+	return result, origin, nil
+}
+
+// Rotate3DOptions controls how Rotate3D rotates an object
+type Rotate3DOptions struct {
+	// Euler gives the angles in degrees to rotate around the X, Y and Z
+	// axes, which are applied in that order. It is used when Axis is zero.
+	Euler geometry.PointF
+
+	// Axis and Angle give a rotation of Angle degrees around an arbitrary
+	// axis. Positive angles follow the right-hand rule.
+	Axis  geometry.PointF
+	Angle float64
+
+	// Pivot is the point to rotate around. nil uses the centre of the
+	// object. The pivot only affects the result when KeepSize is set.
+	Pivot *geometry.PointF
+
+	TransformOptions
+}
+
+// Rotate3D rotates an object around an arbitrary axis, or by a combination
+// of rotations around the X, Y and Z axes
+func Rotate3D(ctx context.Context, v magica.VoxelObject, opts Rotate3DOptions) (r magica.VoxelObject, err error) {
+	rotation := EulerMatrix(opts.Euler)
+	if opts.Axis != (geometry.PointF{}) {
+		if rotation, err = RotationMatrix(opts.Axis, opts.Angle); err != nil {
+			return v.Copy(), err
+		}
+	}
+
+	return Transform(ctx, v, AroundPivot(rotation, opts.Pivot, v.Size), opts.TransformOptions)
+}
+
+// ShearMatrix returns a transform which moves objects along the target axis
+// by gradient voxels for every voxel along the source axis
+func ShearMatrix(source, target Axis, gradient float64) (Matrix, error) {
+	if err := source.validate(); err != nil {
+		return Matrix{}, err
+	}
+
+	if err := target.validate(); err != nil {
+		return Matrix{}, err
+	}
+
+	if source == target {
+		return Matrix{}, fmt.Errorf("shear source and target axes must be different")
+	}
+
+	index := map[Axis]int{AxisX: 0, AxisY: 1, AxisZ: 2}
+	m := IdentityMatrix()
+	m[index[target]][index[source]] = gradient
+	return m, nil
+}
+
+// Shear describes a shear step in a transform
+type Shear struct {
+	Axis     Axis    `json:"axis"`
+	Target   Axis    `json:"target"`
+	Gradient float64 `json:"gradient"`
+}
+
+// TransformStep is a single step in a composed transform. Exactly one of the
+// fields should be set. Rotation and scaling are around the origin.
+type TransformStep struct {
+	// Translate moves the object
+	Translate *geometry.PointF `json:"translate,omitempty"`
+
+	// Rotate gives angles in degrees to rotate around the X, Y and Z axes,
+	// as for EulerMatrix
+	Rotate *geometry.PointF `json:"rotate,omitempty"`
+
+	// Scale scales the object along each axis
+	Scale *geometry.PointF `json:"scale,omitempty"`
+
+	// Shear shears the object
+	Shear *Shear `json:"shear,omitempty"`
+}
+
+// Matrix returns the transform for the step
+func (s TransformStep) Matrix() (Matrix, error) {
+	set := 0
+	for _, present := range []bool{s.Translate != nil, s.Rotate != nil, s.Scale != nil, s.Shear != nil} {
+		if present {
+			set++
+		}
+	}
+
+	if set != 1 {
+		return Matrix{}, fmt.Errorf("transform step must have exactly one of translate, rotate, scale or shear")
+	}
+
+	switch {
+	case s.Translate != nil:
+		return TranslationMatrix(*s.Translate), nil
+	case s.Rotate != nil:
+		return EulerMatrix(*s.Rotate), nil
+	case s.Scale != nil:
+		return ScaleMatrix(*s.Scale), nil
+	}
+
+	return ShearMatrix(s.Shear.Axis, s.Shear.Target, s.Shear.Gradient)
+}
+
+// ComposeSteps returns a transform which applies each step in order
+func ComposeSteps(steps []TransformStep) (Matrix, error) {
+	m := IdentityMatrix()
+
+	for i, step := range steps {
+		sm, err := step.Matrix()
+		if err != nil {
+			return m, fmt.Errorf("step %d: %w", i+1, err)
+		}
+		m = m.Then(sm)
+	}
+
+	return m, nil
+}
+
+// AroundPivot returns a transform which applies m around pivot rather than
+// the origin. A nil pivot uses the centre of an object of the given size.
+func AroundPivot(m Matrix, pivot *geometry.PointF, size geometry.Point) Matrix {
+	p := geometry.PointF{X: float64(size.X) / 2, Y: float64(size.Y) / 2, Z: float64(size.Z) / 2}
+	if pivot != nil {
+		p = *pivot
+	}
+
+	return TranslationMatrix(geometry.PointF{X: -p.X, Y: -p.Y, Z: -p.Z}).
+		Then(m).
+		Then(TranslationMatrix(p))
+}
+
+// ShearOptions controls how ShearObject shears an object
+type ShearOptions struct {
+	Shear
+
+	// Smooth takes several samples per voxel and uses the most common
+	// colour, rather than the nearest voxel
+	Smooth bool
+
+	// Grow resizes the canvas along the target axis so that no voxels are
+	// clipped. By default the canvas is unchanged.
+	Grow bool
+}
+
+// ShearObject moves each voxel along the target axis by gradient voxels for
+// every voxel along the source axis. Fractional gradients are supported.
+func ShearObject(ctx context.Context, v magica.VoxelObject, opts ShearOptions) (r magica.VoxelObject, err error) {
+	m, err := ShearMatrix(opts.Axis, opts.Target, opts.Gradient)
+	if err != nil {
+		return v.Copy(), err
+	}
+
+	transformOpts := TransformOptions{KeepSize: !opts.Grow}
+	if opts.Smooth {
+		transformOpts.Resampling = ResampleModal
+	}
+
+	return Transform(ctx, v, m, transformOpts)
+}
@@ -0,0 +1,82 @@
+package compositor
+
+import (
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"strings"
+)
+
+// Axis is one of the three axes of a voxel object
+type Axis string
+
+const (
+	AxisX Axis = "x"
+	AxisY Axis = "y"
+	AxisZ Axis = "z"
+)
+
+func (a Axis) validate() error {
+	switch a {
+	case AxisX, AxisY, AxisZ:
+		return nil
+	}
+
+	return fmt.Errorf("unknown axis %s", a)
+}
+
+// of returns the component of p along the axis
+func (a Axis) of(p geometry.Point) int {
+	switch a {
+	case AxisX:
+		return p.X
+	case AxisY:
+		return p.Y
+	}
+	return p.Z
+}
+
+// set sets the component of p along the axis
+func (a Axis) set(p *geometry.Point, value int) {
+	switch a {
+	case AxisX:
+		p.X = value
+	case AxisY:
+		p.Y = value
+	default:
+		p.Z = value
+	}
+}
+
+// ParseAxes parses a string of axis letters such as "xz"
+func ParseAxes(s string) ([]Axis, error) {
+	axes := make([]Axis, 0, len(s))
+	for _, r := range strings.ToLower(s) {
+		a := Axis(r)
+		if err := a.validate(); err != nil {
+			return nil, err
+		}
+		axes = append(axes, a)
+	}
+
+	return axes, nil
+}
+
+// Pivot selects the point an object is rotated around
+type Pivot string
+
+const (
+	// PivotCentre rotates around the centre of the object
+	PivotCentre Pivot = "centre"
+	// PivotBottom rotates around the bottom of the object, so that it
+	// stays on the ground
+	PivotBottom Pivot = "bottom"
+)
+
+func (p Pivot) validate() error {
+	switch p {
+	case "", PivotCentre, PivotBottom:
+		return nil
+	}
+
+	return fmt.Errorf("unknown pivot %s", p)
+}
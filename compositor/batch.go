@@ -0,0 +1,796 @@
+package compositor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/mattkimber/cargopositor/internal/utils"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Batch is a set of input files and the operations to apply to each of them
+type Batch struct {
+	Files      []string    `json:"files"`
+	Operations []Operation `json:"operations"`
+
+	// MaskIndex is the default mask colour index for all operations
+	MaskIndex int `json:"mask_index"`
+
+	// Ramps maps names to ramp specifications, which operations can refer
+	// to as @name
+	Ramps map[string]string `json:"ramps"`
+
+	// Include lists JSON files containing further named ramps
+	Include []string `json:"include"`
+}
+
+// BoundingVolume is the region of an object used by RotateAndTile
+type BoundingVolume struct {
+	Min geometry.Point `json:"min"`
+	Max geometry.Point `json:"max"`
+}
+
+// Operation is a single operation within a batch. Type selects the
+// operation, and which of the other fields are used depends on the type.
+type Operation struct {
+	Name              string           `json:"name"`
+	Type              string           `json:"type"`
+	File              string           `json:"file"`
+	InputColourRamp   string           `json:"input_ramp"`
+	OutputColourRamp  string           `json:"output_ramp"`
+	InputColourRamps  []string         `json:"input_ramps"`
+	OutputColourRamps []string         `json:"output_ramps"`
+	N                 int              `json:"n"`
+	XSteps            float64          `json:"x_steps"`
+	ZSteps            int              `json:"z_steps"`
+	Angle             float64          `json:"angle"`
+	XOffset           int              `json:"x_offset"`
+	YOffset           int              `json:"y_offset"`
+	IgnoreMask        bool             `json:"ignore_mask"`
+	Truncate          bool             `json:"truncate"`
+	MaskOriginal      bool             `json:"mask_original"`
+	FlipX             bool             `json:"flip_x"`
+	MaskNew           bool             `json:"mask_new"`
+	Scale             geometry.PointF  `json:"scale"`
+	BoundingVolume    BoundingVolume   `json:"bounding_volume"`
+	Overwrite         bool             `json:"overwrite"`
+	BlendMode         string           `json:"blend_mode"`
+	Layers            []int            `json:"layers"`
+	NoMask            MaskPolicy       `json:"no_mask"`
+	MaskIndex         int              `json:"mask_index"`
+	Region            int              `json:"region"`
+	RecolourMask      bool             `json:"recolour_mask"`
+	RecolourMode      RecolourMode     `json:"recolour_mode"`
+	Regions           []Operation      `json:"regions"`
+	Filter            Filter           `json:"filter"`
+	Priority          []int            `json:"priority"`
+	Command           []string         `json:"command"`
+	Timeout           float64          `json:"timeout"`
+	Axis              string           `json:"axis"`
+	Plane             float64          `json:"plane"`
+	Symmetrize        bool             `json:"symmetrize"`
+	FromMax           bool             `json:"from_max"`
+	Pivot             Pivot            `json:"pivot"`
+	Turns             int              `json:"turns"`
+	Order             string           `json:"order"`
+	Euler             geometry.PointF  `json:"euler"`
+	RotationAxis      geometry.PointF  `json:"rotation_axis"`
+	PivotPoint        *geometry.PointF `json:"pivot_point"`
+	Resampling        Resampling       `json:"resampling"`
+	Samples           int              `json:"samples"`
+	KeepSize          bool             `json:"keep_size"`
+	Matrix            *Matrix          `json:"matrix"`
+	TransformSteps    []TransformStep  `json:"steps"`
+	Target            string           `json:"target"`
+	Gradient          float64          `json:"gradient"`
+	Smooth            bool             `json:"smooth"`
+	Grow              bool             `json:"grow"`
+	Radius            float64          `json:"radius"`
+	ZOffset           int              `json:"z_offset"`
+	Wrap              bool             `json:"wrap"`
+	Trim              bool             `json:"trim"`
+	Size              geometry.Point   `json:"size"`
+	Anchor            Anchors          `json:"anchor"`
+
+	// Parameters holds any additional fields for custom operators
+	Parameters map[string]interface{} `json:"parameters"`
+}
+
+// steps returns the operations to apply for this operation. Usually this is
+// just the operation itself, but if it has regions each region is returned,
+// with any fields it doesn't set taken from the parent operation. As unset
+// fields can't be told apart from zero values, a region can't override a
+// field with its zero value.
+func (op *Operation) steps() []Operation {
+	if len(op.Regions) == 0 {
+		return []Operation{*op}
+	}
+
+	steps := make([]Operation, len(op.Regions))
+	for i, region := range op.Regions {
+		step := *op
+		step.Regions = nil
+
+		parent, child := reflect.ValueOf(&step).Elem(), reflect.ValueOf(region)
+		for f := 0; f < child.NumField(); f++ {
+			if !child.Field(f).IsZero() {
+				parent.Field(f).Set(child.Field(f))
+			}
+		}
+
+		steps[i] = step
+	}
+
+	return steps
+}
+
+// expand returns the steps for an operation, with the batch's default
+// mask index applied and named ramps resolved
+func (b *Batch) expand(op Operation) ([]Operation, error) {
+	if op.MaskIndex == 0 {
+		op.MaskIndex = b.MaskIndex
+	}
+
+	steps := op.steps()
+	for i := range steps {
+		if err := b.resolveRamps(&steps[i]); err != nil {
+			return nil, fmt.Errorf("operation %s (%s): %w", steps[i].Name, steps[i].Type, err)
+		}
+	}
+
+	return steps, nil
+}
+
+// stepFiles returns the source files used by a list of steps
+func stepFiles(steps []Operation) (files []string) {
+	for _, step := range steps {
+		if step.File != "" {
+			files = append(files, step.File)
+		}
+	}
+	return files
+}
+
+// has returns true if the field with the given JSON name is set to a
+// non-zero value, or is present in the operation's custom parameters
+func (op *Operation) has(name string) bool {
+	if _, ok := op.Parameters[name]; ok {
+		return true
+	}
+
+	v := reflect.ValueOf(*op)
+	t := v.Type()
+
+	for i := 0; i < t.NumField(); i++ {
+		if strings.Split(t.Field(i).Tag.Get("json"), ",")[0] == name {
+			return !v.Field(i).IsZero()
+		}
+	}
+
+	return false
+}
+
+// ramps returns the operation's colour ramps, falling back to the single
+// input/output ramp if the arrays are missing or of mismatched lengths
+func (op *Operation) ramps() (inputRamps, outputRamps []string) {
+	if len(op.InputColourRamps) == 0 || len(op.InputColourRamps) != len(op.OutputColourRamps) {
+		return []string{op.InputColourRamp}, []string{op.OutputColourRamp}
+	}
+
+	return op.InputColourRamps, op.OutputColourRamps
+}
+
+func (op *Operation) scaleOptions() ScaleOptions {
+	inputRamps, outputRamps := op.ramps()
+	return ScaleOptions{
+		InputRamps:   inputRamps,
+		OutputRamps:  outputRamps,
+		Region:       op.Region,
+		Scale:        op.Scale,
+		Overwrite:    op.Overwrite,
+		IgnoreMask:   op.IgnoreMask,
+		NoMask:       op.NoMask,
+		MaskIndex:    byte(op.MaskIndex),
+		MaskOriginal: op.MaskOriginal,
+		MaskNew:      op.MaskNew,
+		Filter:       op.Filter,
+		Priority:     op.priority(),
+	}
+}
+
+func (op *Operation) recolourOptions() RecolourOptions {
+	inputRamps, outputRamps := op.ramps()
+	return RecolourOptions{
+		InputRamps:  inputRamps,
+		OutputRamps: outputRamps,
+		IncludeMask: op.RecolourMask,
+		Mode:        op.RecolourMode,
+		MaskIndex:   byte(op.MaskIndex),
+	}
+}
+
+func (op *Operation) symmetrizeOptions() SymmetrizeOptions {
+	return SymmetrizeOptions{
+		Axis:    Axis(op.Axis),
+		Plane:   op.Plane,
+		FromMax: op.FromMax,
+	}
+}
+
+func (op *Operation) transformOptions() TransformOptions {
+	return TransformOptions{
+		Resampling: op.Resampling,
+		Samples:    op.Samples,
+		KeepSize:   op.KeepSize,
+	}
+}
+
+func (op *Operation) rotate3DOptions() Rotate3DOptions {
+	return Rotate3DOptions{
+		Euler:            op.Euler,
+		Axis:             op.RotationAxis,
+		Angle:            op.Angle,
+		Pivot:            op.PivotPoint,
+		TransformOptions: op.transformOptions(),
+	}
+}
+
+// transform returns the operation's transform, from either its matrix or
+// its steps
+func (op *Operation) transform() (Matrix, error) {
+	if op.Matrix != nil && len(op.TransformSteps) > 0 {
+		return Matrix{}, fmt.Errorf("operation %s (%s) has both a matrix and steps", op.Name, op.Type)
+	}
+
+	if op.Matrix != nil {
+		return *op.Matrix, nil
+	}
+
+	if len(op.TransformSteps) == 0 {
+		return Matrix{}, fmt.Errorf("operation %s (%s) has neither a matrix nor steps", op.Name, op.Type)
+	}
+
+	m, err := ComposeSteps(op.TransformSteps)
+	if err != nil {
+		return m, fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+	}
+
+	return m, nil
+}
+
+func (op *Operation) shearOptions() ShearOptions {
+	return ShearOptions{
+		Shear:  Shear{Axis: Axis(op.Axis), Target: Axis(op.Target), Gradient: op.Gradient},
+		Smooth: op.Smooth,
+		Grow:   op.Grow,
+	}
+}
+
+func (op *Operation) bendOptions() BendOptions {
+	return BendOptions{
+		Radius:  op.Radius,
+		Towards: Axis(op.Target),
+		Smooth:  op.Smooth,
+	}
+}
+
+func (op *Operation) translateOptions() TranslateOptions {
+	return TranslateOptions{
+		Offset: geometry.Point{X: op.XOffset, Y: op.YOffset, Z: op.ZOffset},
+		Grow:   op.Grow,
+		Wrap:   op.Wrap,
+	}
+}
+
+func (op *Operation) resizeOptions() ResizeOptions {
+	return ResizeOptions{
+		Scale:     op.Scale,
+		Smooth:    op.Smooth,
+		MaskIndex: byte(op.MaskIndex),
+	}
+}
+
+func (op *Operation) priority() []byte {
+	priority := make([]byte, len(op.Priority))
+	for i, p := range op.Priority {
+		priority[i] = byte(p)
+	}
+	return priority
+}
+
+func (op *Operation) repeatOptions() RepeatOptions {
+	inputRamps, outputRamps := op.ramps()
+	return RepeatOptions{
+		N:                op.N,
+		InputRamps:       inputRamps,
+		OutputRamps:      outputRamps,
+		Overwrite:        op.Overwrite,
+		BlendMode:        op.BlendMode,
+		IgnoreMask:       op.IgnoreMask,
+		NoMask:           op.NoMask,
+		MaskIndex:        byte(op.MaskIndex),
+		Region:           op.Region,
+		IgnoreTruncation: op.Truncate,
+		MaskOriginal:     op.MaskOriginal,
+		MaskNew:          op.MaskNew,
+		FlipX:            op.FlipX,
+	}
+}
+
+func (op *Operation) execOptions() ExecOptions {
+	return ExecOptions{
+		Command: op.Command,
+		Timeout: time.Duration(op.Timeout * float64(time.Second)),
+	}
+}
+
+// FromJson reads a batch from JSON. Included files are loaded relative to
+// the current directory.
+func FromJson(handle io.Reader) (b Batch, err error) {
+	return fromJson(handle, "")
+}
+
+func fromJson(handle io.Reader, directory string) (b Batch, err error) {
+	data, err := ioutil.ReadAll(handle)
+	if err != nil {
+		return
+	}
+
+	if err = json.Unmarshal(data, &b); err != nil {
+		return
+	}
+
+	err = b.loadIncludes(directory)
+	return
+}
+
+// FromFile reads a batch from a JSON file. Included files are loaded
+// relative to the batch file.
+func FromFile(filename string) (b Batch, err error) {
+	handle, err := os.Open(filename)
+	if err != nil {
+		return
+	}
+
+	b, err = fromJson(handle, filepath.Dir(filename))
+	if err != nil {
+		handle.Close()
+		return
+	}
+
+	err = handle.Close()
+	return
+}
+
+func getOutputFileName(directory, filename, suffix string) string {
+	// deal with windows paths
+	filename = strings.Replace(filename, "\\", "/", -1)
+	if len(directory) > 0 {
+		filename = directory + path.Base(filename)
+	}
+
+	lastExtension := strings.LastIndex(filename, ".")
+	if lastExtension != -1 {
+		return filename[:lastExtension] + suffix + ".vox"
+	}
+
+	return filename + suffix + ".vox"
+}
+
+// saveFile writes the object to a temporary file in the same directory and
+// renames it into place, so a failed save never leaves a truncated output
+// (or one which appears to be up to date)
+func saveFile(ctx context.Context, v *magica.VoxelObject, filename string) error {
+	// Encode before creating the file, so nothing is written for an
+	// object which can't be saved
+	data := bytes.Buffer{}
+	if err := v.Save(&data); err != nil {
+		return fmt.Errorf("could not encode output file %s: %v", filename, err)
+	}
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	// Replacing a file keeps its permissions. New files get 0666 less the
+	// umask, as os.Create would give them.
+	perm, keep := os.FileMode(0666), false
+	if info, err := os.Stat(filename); err == nil && info.Mode().IsRegular() {
+		perm, keep = info.Mode().Perm(), true
+	}
+
+	handle, err := createTemp(filename, perm)
+	if err != nil {
+		return fmt.Errorf("could not create output file %s: %v", filename, err)
+	}
+
+	tempFileName := handle.Name()
+
+	if _, err = handle.Write(data.Bytes()); err != nil {
+		handle.Close()
+		os.Remove(tempFileName)
+		return fmt.Errorf("could not write output file %s: %v", filename, err)
+	}
+
+	// The umask may have removed some of the previous file's permissions
+	if keep {
+		if err = handle.Chmod(perm); err != nil {
+			handle.Close()
+			os.Remove(tempFileName)
+			return fmt.Errorf("could not set permissions on output file %s: %v", filename, err)
+		}
+	}
+
+	if err = handle.Close(); err != nil {
+		os.Remove(tempFileName)
+		return fmt.Errorf("could not close output file %s: %v", filename, err)
+	}
+
+	if err = os.Rename(tempFileName, filename); err != nil {
+		os.Remove(tempFileName)
+		return fmt.Errorf("could not replace output file %s: %v", filename, err)
+	}
+
+	return nil
+}
+
+// createTemp creates a new hidden file next to filename with the given
+// permissions, which are subject to the umask. Unlike os.CreateTemp, which
+// always uses 0600, this lets a new output get the usual permissions.
+func createTemp(filename string, perm os.FileMode) (*os.File, error) {
+	dir, base := filepath.Dir(filename), filepath.Base(filename)
+
+	for try := 0; ; try++ {
+		name := filepath.Join(dir, fmt.Sprintf(".%s.%d.tmp", base, rand.Uint32()))
+		handle, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, perm)
+		if os.IsExist(err) && try < 100 {
+			continue
+		}
+		return handle, err
+	}
+}
+
+// Summary reports the outcome of a batch run
+type Summary struct {
+	// Written lists the output files which were saved
+	Written []string
+	// UpToDate lists the output files which were newer than their inputs
+	UpToDate []string
+	// Skipped lists the output files which were not produced because their
+	// input had no mask voxels and the operation's no_mask policy is skip
+	Skipped []string
+	// NoMask lists the inputs which had no mask voxels for an operation
+	// that uses the mask
+	NoMask []NoMaskInput
+}
+
+// NoMaskInput is an input which had no mask voxels for an operation
+type NoMaskInput struct {
+	Input     string
+	Operation string
+	Policy    MaskPolicy
+}
+
+// Run applies every operation to every input file, saving the results to
+// outputDirectory. Outputs which are newer than their inputs are skipped.
+// If ctx is cancelled Run stops before the next operation and returns the
+// context's error.
+func (b *Batch) Run(ctx context.Context, outputDirectory, voxelDirectory string) (summary Summary, err error) {
+	err = b.process(ctx, outputDirectory, voxelDirectory, &summary, true, func(output *magica.VoxelObject, filename string) error {
+		if err := saveFile(ctx, output, filename); err != nil {
+			return err
+		}
+
+		summary.Written = append(summary.Written, filename)
+		return nil
+	})
+
+	return summary, err
+}
+
+// CheckStatus describes how an existing output compares to the output a
+// batch would produce
+type CheckStatus string
+
+const (
+	// CheckCurrent means the existing output matches
+	CheckCurrent CheckStatus = "current"
+	// CheckMissing means there is no existing output
+	CheckMissing CheckStatus = "missing"
+	// CheckDifferent means the existing output differs
+	CheckDifferent CheckStatus = "different"
+	// CheckSkipped means no output is produced, because the input has no
+	// mask voxels and the operation's no_mask policy is skip
+	CheckSkipped CheckStatus = "skipped"
+)
+
+// CheckResult is the result of checking a single output file
+type CheckResult struct {
+	Filename string
+	Status   CheckStatus
+}
+
+// Check applies every operation to every input file in memory, and compares
+// the results byte-for-byte with the existing files in outputDirectory.
+// Nothing is written. It returns one result per output, including outputs
+// skipped for having no mask, along with the same summary as Run.
+func (b *Batch) Check(ctx context.Context, outputDirectory, voxelDirectory string) (results []CheckResult, summary Summary, err error) {
+	err = b.process(ctx, outputDirectory, voxelDirectory, &summary, false, func(output *magica.VoxelObject, filename string) error {
+		status, err := compareToFile(output, filename)
+		if err != nil {
+			return err
+		}
+
+		results = append(results, CheckResult{Filename: filename, Status: status})
+		return nil
+	})
+
+	for _, filename := range summary.Skipped {
+		results = append(results, CheckResult{Filename: filename, Status: CheckSkipped})
+	}
+
+	return results, summary, err
+}
+
+func compareToFile(v *magica.VoxelObject, filename string) (CheckStatus, error) {
+	if _, err := os.Stat(filename); os.IsNotExist(err) {
+		return CheckMissing, nil
+	}
+
+	data := bytes.Buffer{}
+	if err := v.Save(&data); err != nil {
+		return "", fmt.Errorf("could not encode output file %s: %v", filename, err)
+	}
+
+	equal, err := utils.CompareToFile(data.Bytes(), filename)
+	if err != nil {
+		return "", fmt.Errorf("could not read output file %s: %v", filename, err)
+	}
+
+	if !equal {
+		return CheckDifferent, nil
+	}
+
+	return CheckCurrent, nil
+}
+
+// process applies every operation to every input file and passes the
+// results to emit. If skipCurrent is set, outputs which are newer than
+// their inputs are skipped.
+func (b *Batch) process(ctx context.Context, outputDirectory, voxelDirectory string, summary *Summary, skipCurrent bool, emit func(output *magica.VoxelObject, filename string) error) error {
+	if len(voxelDirectory) > 0 && !strings.HasSuffix(voxelDirectory, "/") {
+		voxelDirectory = voxelDirectory + "/"
+	}
+
+	if len(outputDirectory) > 0 && !strings.HasSuffix(outputDirectory, "/") {
+		outputDirectory = outputDirectory + "/"
+	}
+
+	if err := b.Validate(); err != nil {
+		return err
+	}
+
+	// Start with at least the length of files, as we know we have this many
+	expandedFiles := make([]string, 0, len(b.Files))
+
+	// Expand file paths
+	for _, fileSpec := range b.Files {
+		files, err := filepath.Glob(voxelDirectory + fileSpec)
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			expandedFiles = append(expandedFiles, file)
+		}
+	}
+
+	for _, f := range expandedFiles {
+		var input magica.VoxelObject
+
+		for _, op := range b.Operations {
+			if err := ctx.Err(); err != nil {
+				return fmt.Errorf("batch stopped before operation %s (%s) on %s: %w", op.Name, op.Type, f, err)
+			}
+
+			outputFileName := getOutputFileName(outputDirectory, f, op.Name)
+			steps, err := b.expand(op)
+			if err != nil {
+				return err
+			}
+
+			if skipCurrent {
+				newer, err := inputFileIsNewerThanOutput(f, voxelDirectory, stepFiles(steps), outputFileName)
+				if err != nil {
+					return fmt.Errorf("could not stat input and/or output files: %w", err)
+				}
+
+				if !newer {
+					summary.UpToDate = append(summary.UpToDate, outputFileName)
+					continue
+				}
+			}
+
+			if (input.Size.X == 0 && input.Size.Y == 0 && input.Size.Z == 0) || len(op.Layers) > 0 {
+				input, err = magica.FromFileWithLayers(f, op.Layers)
+				if err != nil {
+					return fmt.Errorf("could not open input file %s: %v", f, err)
+				}
+			}
+
+			output, skipped := input, false
+
+			for idx, step := range steps {
+				stepOutput, err := b.applyStep(ctx, input, step, f, voxelDirectory, summary)
+				if errors.Is(err, ErrNoMask) && step.NoMask == MaskPolicySkip {
+					skipped = true
+					break
+				}
+
+				if err != nil {
+					return fmt.Errorf("could not apply operation %s (%s) to %s: %w", op.Name, step.Type, f, err)
+				}
+
+				if idx == 0 {
+					output = stepOutput
+				} else if output, err = mergeChanges(input, output, stepOutput); err != nil {
+					return fmt.Errorf("could not apply operation %s (%s) to %s: %w", op.Name, step.Type, f, err)
+				}
+			}
+
+			if skipped {
+				summary.Skipped = append(summary.Skipped, outputFileName)
+				continue
+			}
+
+			if err := emit(&output, outputFileName); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// applyStep loads the sources for a single operation step and applies it
+// to the input
+func (b *Batch) applyStep(ctx context.Context, input magica.VoxelObject, step Operation, f, voxelDirectory string, summary *Summary) (magica.VoxelObject, error) {
+	operator, _ := LookupOperator(step.Type)
+
+	var sources []magica.VoxelObject
+	if step.File != "" {
+		src, err := magica.FromFile(voxelDirectory + step.File)
+		if err != nil {
+			return magica.VoxelObject{}, fmt.Errorf("error opening voxel file %s: %v", voxelDirectory+step.File, err)
+		}
+		sources = append(sources, src)
+	}
+
+	if usesMask(operator, step) && !HasMask(&input, byte(step.MaskIndex)) {
+		policy := step.NoMask
+		if policy == "" {
+			policy = MaskPolicyError
+		}
+		summary.NoMask = append(summary.NoMask, NoMaskInput{Input: f, Operation: step.Name, Policy: policy})
+	}
+
+	return operator.Apply(ctx, input, sources, step)
+}
+
+// mergeChanges copies every voxel which differs between input and changed
+// into output. Each region step is applied to the original input, so that
+// region numbers don't change as earlier regions are filled, and the
+// results are merged in this way.
+func mergeChanges(input, output, changed magica.VoxelObject) (magica.VoxelObject, error) {
+	if changed.Size != input.Size || output.Size != input.Size {
+		return output, fmt.Errorf("region operations cannot change the size of the object")
+	}
+
+	output.Iterate(func(x, y, z int) {
+		if changed.Voxels[x][y][z] != input.Voxels[x][y][z] {
+			output.Voxels[x][y][z] = changed.Voxels[x][y][z]
+		}
+	})
+
+	return output, nil
+}
+
+// usesMask returns true if the operation depends on the input's mask
+// voxels, which is the case for operators with a no_mask policy unless the
+// mask is ignored
+func usesMask(operator Operator, op Operation) bool {
+	if op.IgnoreMask {
+		return false
+	}
+
+	for _, p := range operator.Parameters() {
+		if p.Name == "no_mask" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Validate checks that every operation in the batch refers to a registered
+// operator and has valid parameters
+func (b *Batch) Validate() error {
+	if b.MaskIndex < 0 || b.MaskIndex > 255 {
+		return fmt.Errorf("mask index %d is not valid: use 1-255 to set the mask colour, or 0 for the default", b.MaskIndex)
+	}
+
+	for _, op := range b.Operations {
+		for _, region := range op.Regions {
+			if len(region.Regions) > 0 {
+				return fmt.Errorf("operation %s (%s): regions cannot contain further regions", op.Name, op.Type)
+			}
+		}
+
+		steps, err := b.expand(op)
+		if err != nil {
+			return err
+		}
+
+		for _, step := range steps {
+			if step.MaskIndex < 0 || step.MaskIndex > 255 {
+				return fmt.Errorf("operation %s (%s): mask index %d is not valid: use 1-255 to set the mask colour, or 0 for the default", step.Name, step.Type, step.MaskIndex)
+			}
+
+			if step.Region < 0 {
+				return fmt.Errorf("operation %s (%s): region %d is not valid", step.Name, step.Type, step.Region)
+			}
+
+			operator, ok := LookupOperator(step.Type)
+			if !ok {
+				return fmt.Errorf("unknown operation %s", step.Type)
+			}
+
+			if err := operator.Validate(step); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func inputFileIsNewerThanOutput(input, voxelDir string, opfiles []string, output string) (bool, error) {
+	in, err := os.Stat(input)
+	if err != nil {
+		return false, err
+	}
+
+	out, err := os.Stat(output)
+	if err != nil {
+		// By default if we can't stat the output file, replace it
+		return true, nil
+	}
+
+	if in.ModTime().After(out.ModTime()) {
+		return true, nil
+	}
+
+	for _, opfile := range opfiles {
+		in, err := os.Stat(voxelDir + opfile)
+		if err != nil {
+			return false, err
+		}
+
+		if in.ModTime().After(out.ModTime()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
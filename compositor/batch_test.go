@@ -0,0 +1,558 @@
+package compositor
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"github.com/mattkimber/cargopositor/internal/utils"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFromFile(t *testing.T) {
+	batch, err := FromFile("testdata/batch_example.json")
+	expected := Batch{
+		Files:      []string{"example_input.vox"},
+		Operations: []Operation{{Name: "empty", File: "", Type: "produce_empty", InputColourRamp: "20,30"}},
+	}
+
+	if err != nil {
+		t.Errorf("Error reading file: %v", err)
+	}
+
+	if !reflect.DeepEqual(batch, expected) {
+		t.Errorf("Expected %v, got %v", expected, batch)
+	}
+}
+
+func TestRunCancelled(t *testing.T) {
+	batch, err := FromFile("testdata/batch_example.json")
+	if err != nil {
+		t.Fatalf("Error reading file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	outputDirectory := t.TempDir()
+	if _, err := batch.Run(ctx, outputDirectory, "testdata"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if files, _ := os.ReadDir(outputDirectory); len(files) != 0 {
+		t.Errorf("Expected no output files, got %d", len(files))
+	}
+}
+
+func TestRunCancelledDuringOperation(t *testing.T) {
+	batch := Batch{
+		Files:      []string{"example_input.vox"},
+		Operations: []Operation{{Name: "large", Type: "resize", Scale: geometry.PointF{X: 30, Y: 30, Z: 30}, Smooth: true}},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	time.AfterFunc(50*time.Millisecond, cancel)
+
+	outputDirectory := t.TempDir()
+	start := time.Now()
+	if _, err := batch.Run(ctx, outputDirectory, "testdata"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected cancellation to stop the operation promptly, took %v", elapsed)
+	}
+
+	if files, _ := os.ReadDir(outputDirectory); len(files) != 0 {
+		t.Errorf("Expected no output files, got %d", len(files))
+	}
+}
+
+func TestSaveFile(t *testing.T) {
+	v, err := magica.FromFile("testdata/example_input.vox")
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	outputDirectory := t.TempDir()
+	filename := filepath.Join(outputDirectory, "output.vox")
+
+	if err := os.WriteFile(filename, []byte("previous"), 0644); err != nil {
+		t.Fatalf("Could not write previous output: %v", err)
+	}
+
+	// A failed save must leave the previous output untouched
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := saveFile(ctx, &v, filename); err == nil {
+		t.Errorf("Expected error saving with a cancelled context")
+	}
+
+	if data, _ := os.ReadFile(filename); string(data) != "previous" {
+		t.Errorf("Previous output was modified by a failed save")
+	}
+
+	if err := saveFile(context.Background(), &v, filename); err != nil {
+		t.Fatalf("Could not save file: %v", err)
+	}
+
+	if result, err := utils.CompareToFile(mustEncode(t, v), filename); err != nil || !result {
+		t.Errorf("Saved output did not equal object (err: %v)", err)
+	}
+
+	// Replacing a file keeps its permissions, even ones the umask would
+	// remove
+	if err := os.Chmod(filename, 0664); err != nil {
+		t.Fatalf("Could not change permissions: %v", err)
+	}
+
+	if err := saveFile(context.Background(), &v, filename); err != nil {
+		t.Fatalf("Could not save file: %v", err)
+	}
+
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatalf("Could not stat file: %v", err)
+	}
+
+	if info.Mode().Perm() != 0664 {
+		t.Errorf("Expected replaced file to keep mode 0664, got %v", info.Mode().Perm())
+	}
+
+	// New files get the same permissions as os.Create would give them
+	probe := filepath.Join(t.TempDir(), "probe")
+	handle, err := os.Create(probe)
+	if err != nil {
+		t.Fatalf("Could not create file: %v", err)
+	}
+	handle.Close()
+
+	created := filepath.Join(t.TempDir(), "created.vox")
+	if err := saveFile(context.Background(), &v, created); err != nil {
+		t.Fatalf("Could not save file: %v", err)
+	}
+
+	expected, err := os.Stat(probe)
+	if err != nil {
+		t.Fatalf("Could not stat file: %v", err)
+	}
+
+	if info, err = os.Stat(created); err != nil {
+		t.Fatalf("Could not stat file: %v", err)
+	}
+
+	if info.Mode().Perm() != expected.Mode().Perm() {
+		t.Errorf("Expected new file to have mode %v, got %v", expected.Mode().Perm(), info.Mode().Perm())
+	}
+
+	// If the output can't be replaced, the temporary file is cleaned up
+	blocked := filepath.Join(outputDirectory, "blocked.vox")
+	if err := os.MkdirAll(filepath.Join(blocked, "contents"), 0755); err != nil {
+		t.Fatalf("Could not create directory: %v", err)
+	}
+
+	if err := saveFile(context.Background(), &v, blocked); err == nil {
+		t.Errorf("Expected error replacing a directory")
+	}
+
+	files, _ := os.ReadDir(outputDirectory)
+	if len(files) != 2 {
+		t.Errorf("Expected no temporary files to remain, got %d files", len(files))
+	}
+}
+
+func mustEncode(t *testing.T, v magica.VoxelObject) []byte {
+	buf := bytes.Buffer{}
+	if err := v.Save(&buf); err != nil {
+		t.Fatalf("Could not encode object: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func TestCheck(t *testing.T) {
+	batch := Batch{
+		Files: []string{"example_input.vox"},
+		Operations: []Operation{
+			{Name: "_identity", Type: "identity"},
+			{Name: "_empty", Type: "produce_empty"},
+		},
+	}
+
+	outputDirectory := t.TempDir()
+	identity := filepath.Join(outputDirectory, "example_input_identity.vox")
+	empty := filepath.Join(outputDirectory, "example_input_empty.vox")
+
+	statuses := func() map[string]CheckStatus {
+		results, _, err := batch.Check(context.Background(), outputDirectory, "testdata")
+		if err != nil {
+			t.Fatalf("Could not check batch: %v", err)
+		}
+
+		statuses := map[string]CheckStatus{}
+		for _, r := range results {
+			statuses[filepath.Clean(r.Filename)] = r.Status
+		}
+		return statuses
+	}
+
+	if s := statuses(); s[identity] != CheckMissing || s[empty] != CheckMissing {
+		t.Errorf("Expected missing outputs, got %v", s)
+	}
+
+	if files, _ := os.ReadDir(outputDirectory); len(files) != 0 {
+		t.Errorf("Check wrote %d files", len(files))
+	}
+
+	if _, err := batch.Run(context.Background(), outputDirectory, "testdata"); err != nil {
+		t.Fatalf("Could not run batch: %v", err)
+	}
+
+	if s := statuses(); s[identity] != CheckCurrent || s[empty] != CheckCurrent {
+		t.Errorf("Expected current outputs, got %v", s)
+	}
+
+	if err := os.WriteFile(empty, []byte("stale"), 0644); err != nil {
+		t.Fatalf("Could not modify output: %v", err)
+	}
+
+	if s := statuses(); s[identity] != CheckCurrent || s[empty] != CheckDifferent {
+		t.Errorf("Expected one different output, got %v", s)
+	}
+}
+
+func TestRunNoMask(t *testing.T) {
+	voxelDirectory := t.TempDir()
+
+	input := magica.NewVoxelObject(geometry.Point{X: 4, Y: 4, Z: 4}, nil)
+	input.Voxels[1][1][1] = 5
+	if err := input.SaveToFile(filepath.Join(voxelDirectory, "input.vox")); err != nil {
+		t.Fatalf("Could not save input: %v", err)
+	}
+
+	cargo := magica.NewVoxelObject(geometry.Point{X: 2, Y: 2, Z: 2}, nil)
+	cargo.Voxels[0][0][0] = 7
+	if err := cargo.SaveToFile(filepath.Join(voxelDirectory, "cargo.vox")); err != nil {
+		t.Fatalf("Could not save cargo: %v", err)
+	}
+
+	testCases := []struct {
+		policy        MaskPolicy
+		expectError   bool
+		expectWritten int
+	}{
+		{"", true, 0},
+		{MaskPolicyError, true, 0},
+		{MaskPolicySkip, false, 0},
+		{MaskPolicyWhole, false, 1},
+	}
+
+	for _, tc := range testCases {
+		batch := Batch{
+			Files:      []string{"input.vox"},
+			Operations: []Operation{{Name: "_cargo", Type: "repeat", File: "cargo.vox", NoMask: tc.policy}},
+		}
+
+		summary, err := batch.Run(context.Background(), t.TempDir(), voxelDirectory)
+		if tc.expectError != errors.Is(err, ErrNoMask) {
+			t.Errorf("Policy %q: expected ErrNoMask %v, got %v", tc.policy, tc.expectError, err)
+		}
+
+		if len(summary.Written) != tc.expectWritten {
+			t.Errorf("Policy %q: expected %d outputs, got %d", tc.policy, tc.expectWritten, len(summary.Written))
+		}
+
+		if len(summary.NoMask) != 1 || summary.NoMask[0].Operation != "_cargo" {
+			t.Errorf("Policy %q: expected input to be reported as having no mask, got %v", tc.policy, summary.NoMask)
+		}
+
+		if skipped := tc.policy == MaskPolicySkip; skipped != (len(summary.Skipped) == 1) {
+			t.Errorf("Policy %q: unexpected skipped outputs %v", tc.policy, summary.Skipped)
+		}
+
+		// Check reports the same warnings, and a result for skipped outputs
+		results, checkSummary, err := batch.Check(context.Background(), t.TempDir(), voxelDirectory)
+		if tc.expectError != errors.Is(err, ErrNoMask) {
+			t.Errorf("Policy %q: expected ErrNoMask %v from check, got %v", tc.policy, tc.expectError, err)
+		}
+
+		if !reflect.DeepEqual(checkSummary.NoMask, summary.NoMask) || len(checkSummary.Skipped) != len(summary.Skipped) {
+			t.Errorf("Policy %q: expected check summary %v, got %v", tc.policy, summary, checkSummary)
+		}
+
+		if tc.policy == MaskPolicySkip && (len(results) != 1 || results[0].Status != CheckSkipped) {
+			t.Errorf("Policy %q: expected a skipped check result, got %v", tc.policy, results)
+		}
+	}
+
+	batch := Batch{Operations: []Operation{{Type: "scale", File: "cargo.vox", NoMask: "sometimes"}}}
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error for unknown mask policy")
+	}
+}
+
+func TestValidateMaskIndex(t *testing.T) {
+	batch := Batch{MaskIndex: 256, Operations: []Operation{{Type: "produce_empty"}}}
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error for out of range batch mask index")
+	}
+
+	batch = Batch{Operations: []Operation{{Type: "produce_empty", MaskIndex: -1}}}
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error for out of range operation mask index")
+	}
+
+	// 0 means the default rather than a mask colour
+	batch = Batch{Operations: []Operation{{Type: "produce_empty", MaskIndex: 0}}}
+	if err := batch.Validate(); err != nil {
+		t.Errorf("Unexpected error for default mask index: %v", err)
+	}
+}
+
+func TestRunRegions(t *testing.T) {
+	voxelDirectory := t.TempDir()
+
+	input := magica.NewVoxelObject(geometry.Point{X: 6, Y: 2, Z: 2}, nil)
+	input.Iterate(func(x, y, z int) {
+		if x != 2 && x != 3 {
+			input.Voxels[x][y][z] = 255
+		}
+	})
+
+	cargo := magica.NewVoxelObject(geometry.Point{X: 1, Y: 1, Z: 1}, nil)
+	cargo.Voxels[0][0][0] = 10
+
+	for name, v := range map[string]magica.VoxelObject{"input.vox": input, "cargo.vox": cargo} {
+		if err := v.SaveToFile(filepath.Join(voxelDirectory, name)); err != nil {
+			t.Fatalf("Could not save %s: %v", name, err)
+		}
+	}
+
+	batch := Batch{
+		Files: []string{"input.vox"},
+		Operations: []Operation{{
+			Name: "_regions",
+			Type: "repeat",
+			File: "cargo.vox",
+			Regions: []Operation{
+				{Region: 1, InputColourRamp: "10-11", OutputColourRamp: "20-21"},
+				{Region: 2, N: 1},
+			},
+		}},
+	}
+
+	outputDirectory := t.TempDir()
+	if _, err := batch.Run(context.Background(), outputDirectory, voxelDirectory); err != nil {
+		t.Fatalf("Could not run batch: %v", err)
+	}
+
+	output, err := magica.FromFile(filepath.Join(outputDirectory, "input_regions.vox"))
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+
+	counts := map[byte]int{}
+	output.Iterate(func(x, y, z int) { counts[output.Voxels[x][y][z]]++ })
+
+	// Region 1 is entirely recoloured cargo, region 2 has a single item
+	if counts[20] != 8 || counts[10] != 1 {
+		t.Errorf("Unexpected colour counts %v", counts)
+	}
+}
+
+func TestValidateNestedRegions(t *testing.T) {
+	batch := Batch{
+		Operations: []Operation{{
+			Name:    "_regions",
+			Type:    "repeat",
+			File:    "cargo.vox",
+			Regions: []Operation{{Region: 1, Regions: []Operation{{Region: 2}}}},
+		}},
+	}
+
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error validating nested regions")
+	}
+
+	batch.Operations[0].Regions[0].Regions = nil
+	if err := batch.Validate(); err != nil {
+		t.Errorf("Unexpected error validating regions: %v", err)
+	}
+}
+
+func TestNamedRamps(t *testing.T) {
+	directory := t.TempDir()
+
+	files := map[string]string{
+		"ramps.json": `{"ramps": {"bulk_base": "3-12", "iron_ore": "72-79", "coal": "1-8"}}`,
+		"batch.json": `{
+			"include": ["ramps.json"],
+			"ramps": {"coal": "1-4"},
+			"operations": [
+				{"name": "iron", "type": "recolour", "input_ramp": "@bulk_base", "output_ramp": "@iron_ore"},
+				{"name": "coal", "type": "recolour", "input_ramps": ["@bulk_base"], "output_ramps": ["@coal"]}
+			]
+		}`,
+	}
+
+	for name, contents := range files {
+		if err := os.WriteFile(filepath.Join(directory, name), []byte(contents), 0644); err != nil {
+			t.Fatalf("Could not write %s: %v", name, err)
+		}
+	}
+
+	batch, err := FromFile(filepath.Join(directory, "batch.json"))
+	if err != nil {
+		t.Fatalf("Could not read batch: %v", err)
+	}
+
+	if err := batch.Validate(); err != nil {
+		t.Errorf("Unexpected validation error: %v", err)
+	}
+
+	for i, expected := range []string{"3-12|72-79", "3-12|1-4"} {
+		steps, err := batch.expand(batch.Operations[i])
+		if err != nil {
+			t.Fatalf("Could not expand operation %d: %v", i, err)
+		}
+
+		in, out := steps[0].ramps()
+		if actual := in[0] + "|" + out[0]; actual != expected {
+			t.Errorf("Operation %d: expected ramps %s, got %s", i, expected, actual)
+		}
+	}
+
+	expectedUsage := map[string][]string{
+		"bulk_base": {"coal", "iron"},
+		"iron_ore":  {"iron"},
+		"coal":      {"coal"},
+	}
+
+	if usage := batch.RampUsage(); !reflect.DeepEqual(usage, expectedUsage) {
+		t.Errorf("Expected usage %v, got %v", expectedUsage, usage)
+	}
+
+	batch.Operations = append(batch.Operations, Operation{Type: "recolour", InputColourRamp: "@missing", OutputColourRamp: "1-2"})
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error for unknown named ramp")
+	}
+}
+
+func TestValidateScale(t *testing.T) {
+	testCases := []struct {
+		json  string
+		valid bool
+	}{
+		{`{"type": "scale", "file": "cargo.vox", "scale": {"x": 0, "y": 0.5, "z": 1}}`, true},
+		{`{"type": "scale", "file": "cargo.vox", "scale": {"x": 2, "y": 2, "z": 2}}`, false},
+		{`{"type": "scale", "file": "cargo.vox", "scale": {"z": -0.5}}`, false},
+	}
+
+	for _, tc := range testCases {
+		batch, err := FromJson(strings.NewReader(`{"operations": [` + tc.json + `]}`))
+		if err != nil {
+			t.Fatalf("Could not parse batch: %v", err)
+		}
+
+		if err := batch.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid %v, got error %v", tc.json, tc.valid, err)
+		}
+	}
+}
+
+func TestValidateRecolour(t *testing.T) {
+	testCases := []struct {
+		json  string
+		valid bool
+	}{
+		{`{"type": "recolour", "input_ramp": "3-12", "output_ramp": "72-79"}`, true},
+		{`{"type": "recolour", "input_ramps": ["3-12", "20-24"], "output_ramps": ["72-79", "30-34"]}`, true},
+		{`{"type": "recolour", "recolour_mode": "palette", "file": "palette.vox"}`, true},
+		{`{"type": "recolour", "input_ramp": "3-12"}`, false},
+		{`{"type": "recolour", "input_ramp": "3-12", "recolour_mode": "luminance"}`, false},
+		{`{"type": "recolour", "input_ramps": ["3-12", "20-24"], "output_ramps": ["72-79"]}`, false},
+		{`{"type": "recolour", "input_ramps": ["3-12"]}`, false},
+		{`{"type": "recolour", "recolour_mode": "palette", "file": "palette.vox", "input_ramps": ["3-12"]}`, false},
+	}
+
+	for _, tc := range testCases {
+		batch, err := FromJson(strings.NewReader(`{"operations": [` + tc.json + `]}`))
+		if err != nil {
+			t.Fatalf("Could not parse batch: %v", err)
+		}
+
+		if err := batch.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid %v, got error %v", tc.json, tc.valid, err)
+		}
+	}
+}
+
+func TestValidateTransform(t *testing.T) {
+	testCases := []struct {
+		json  string
+		valid bool
+	}{
+		{`{"type": "transform", "matrix": [[1, 0, 0, 0], [0, 1, 0, 0], [0, 0, 0.5, 0]]}`, true},
+		{`{"type": "transform", "steps": [{"scale": {"x": 1, "y": 1, "z": 0.5}}, {"shear": {"axis": "x", "target": "z", "gradient": 0.25}}]}`, true},
+		{`{"type": "transform"}`, false},
+		{`{"type": "transform", "matrix": [[1, 0, 0, 0], [0, 1, 0, 0], [0, 0, 0, 0]]}`, false},
+		{`{"type": "transform", "matrix": [[1, 0, 0, 0], [0, 1, 0, 0], [0, 0, 1, 0]], "steps": [{"translate": {"x": 1}}]}`, false},
+		{`{"type": "transform", "steps": [{"rotate": {"z": 10}}], "resampling": "cubic"}`, false},
+	}
+
+	for _, tc := range testCases {
+		batch, err := FromJson(strings.NewReader(`{"operations": [` + tc.json + `]}`))
+		if err != nil {
+			t.Fatalf("Could not parse batch: %v", err)
+		}
+
+		if err := batch.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%s: expected valid %v, got error %v", tc.json, tc.valid, err)
+		}
+	}
+}
+
+func TestCheckBendRadius(t *testing.T) {
+	batch := Batch{Files: []string{"example_input.vox"}}
+
+	// A zero radius is invalid for any object
+	batch.Operations = []Operation{{Name: "bend", Type: "bend", Target: "y"}}
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error validating a zero bend radius")
+	}
+
+	// Small radii depend on the object, so are reported by -check
+	for _, radius := range []float64{2, -5} {
+		batch.Operations = []Operation{{Name: "bend", Type: "bend", Target: "y", Radius: radius}}
+		if _, _, err := batch.Check(context.Background(), t.TempDir(), "testdata"); err == nil {
+			t.Errorf("Expected error checking bend radius %g", radius)
+		}
+	}
+}
+
+func TestValidateRotateAxis(t *testing.T) {
+	testCases := []struct {
+		op    Operation
+		valid bool
+	}{
+		{Operation{Type: "rotate_axis", Axis: "z", Angle: 30}, true},
+		{Operation{Type: "rotate_axis", Axis: "x", Angle: 30, Pivot: PivotBottom}, true},
+		{Operation{Type: "rotate_axis", Angle: 30}, false},
+		{Operation{Type: "rotate_axis", Axis: "xy", Angle: 30}, false},
+		{Operation{Type: "rotate_axis", Axis: "z", Angle: 30, Pivot: PivotBottom}, false},
+	}
+
+	for _, tc := range testCases {
+		batch := Batch{Operations: []Operation{tc.op}}
+		if err := batch.Validate(); (err == nil) != tc.valid {
+			t.Errorf("%v: expected valid %v, got error %v", tc.op, tc.valid, err)
+		}
+	}
+}
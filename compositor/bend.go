@@ -0,0 +1,129 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+)
+
+// BendOptions controls how Bend curves an object
+type BendOptions struct {
+	// Radius is the radius of the arc, in voxels, measured to the centre
+	// line of the object. Positive radii curve the ends of the object
+	// towards the positive Towards axis, negative radii the other way.
+	Radius float64
+
+	// Towards is the axis the object curves towards, either AxisY for a
+	// curve in the XY plane or AxisZ for the XZ plane
+	Towards Axis
+
+	// Smooth takes several samples per voxel and uses the most common
+	// colour, rather than the nearest voxel
+	Smooth bool
+}
+
+// validate checks the options which don't depend on the object being bent
+func (opts BendOptions) validate() error {
+	if opts.Towards != AxisY && opts.Towards != AxisZ {
+		return fmt.Errorf("bend must be towards the y or z axis, not %s", opts.Towards)
+	}
+
+	if opts.Radius == 0 || math.IsNaN(opts.Radius) || math.IsInf(opts.Radius, 0) {
+		return fmt.Errorf("bend radius %g is not valid", opts.Radius)
+	}
+
+	return nil
+}
+
+// Bend maps the X axis of an object onto a circular arc, keeping its length
+// along the centre line. Each output voxel is sampled from the input, so
+// the result has no holes. The canvas is resized to fit the bent object.
+// The radius must be more than half the object's thickness along the
+// Towards axis, and large enough that the arc is less than a full circle.
+func Bend(ctx context.Context, v magica.VoxelObject, opts BendOptions) (r magica.VoxelObject, err error) {
+	if err := opts.validate(); err != nil {
+		return v.Copy(), err
+	}
+
+	b := opts.Towards
+	radius := opts.Radius
+
+	// A smaller radius would fold the inside of the curve over itself, and
+	// a longer arc would wrap around onto the start of the object
+	if thickness := b.of(v.Size); math.Abs(radius) <= float64(thickness)/2 {
+		return v.Copy(), fmt.Errorf("bend radius %g must be more than half the object's thickness of %d along the %s axis", radius, thickness, b)
+	}
+
+	if float64(v.Size.X)/math.Abs(radius) > 2*math.Pi {
+		return v.Copy(), fmt.Errorf("bend radius %g is too small for an object of length %d, which would curve more than a full circle", radius, v.Size.X)
+	}
+
+	sign := math.Copysign(1, radius)
+	centreX := float64(v.Size.X) / 2
+	centreB := float64(b.of(v.Size)) / 2
+
+	// d is the offset of a point from the centre line along the bend axis
+	forward := func(x, d float64) (float64, float64) {
+		theta := (x - centreX) / radius
+		return centreX + (radius-d)*math.Sin(theta), centreB + radius - (radius-d)*math.Cos(theta)
+	}
+
+	inverse := func(p geometry.PointF) geometry.PointF {
+		pb := p.Y
+		if b == AxisZ {
+			pb = p.Z
+		}
+
+		dx, db := p.X-centreX, centreB+radius-pb
+		theta := math.Atan2(sign*dx, sign*db)
+		d := radius - sign*math.Hypot(dx, db)
+
+		result := p
+		result.X = centreX + radius*theta
+		if b == AxisY {
+			result.Y = centreB + d
+		} else {
+			result.Z = centreB + d
+		}
+		return result
+	}
+
+	// Fit the canvas by following the edges of the object around the arc
+	min, max := math.Inf(1), math.Inf(-1)
+	minX, maxX := math.Inf(1), math.Inf(-1)
+	for i := 0; i <= v.Size.X*4; i++ {
+		x := float64(i) / 4
+		for _, d := range []float64{-centreB, centreB} {
+			fx, fb := forward(x, d)
+			minX, maxX = math.Min(minX, fx), math.Max(maxX, fx)
+			min, max = math.Min(min, fb), math.Max(max, fb)
+		}
+	}
+
+	// Ignore slivers of less than a hundredth of a voxel, so gentle curves
+	// don't gain a whole extra layer
+	const tolerance = 0.01
+	size, origin := v.Size, geometry.PointF{X: minX}
+	size.X = int(math.Ceil(maxX - minX - tolerance))
+	b.set(&size, int(math.Ceil(max-min-tolerance)))
+	if b == AxisY {
+		origin.Y = min
+	} else {
+		origin.Z = min
+	}
+
+	for _, s := range []int{size.X, b.of(size)} {
+		if s > maxTransformSize {
+			return v.Copy(), fmt.Errorf("bent object would be %d voxels across, which is more than %d", s, maxTransformSize)
+		}
+	}
+
+	samples := 1
+	if opts.Smooth {
+		samples = defaultSamples
+	}
+
+	return resample(ctx, &v, size, origin, samples, inverse)
+}
@@ -0,0 +1,194 @@
+package compositor
+
+import (
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+)
+
+// TranslateOptions controls how Translate moves an object
+type TranslateOptions struct {
+	// Offset is the number of voxels to move along each axis
+	Offset geometry.Point
+
+	// Grow enlarges the canvas by the offset along each axis, so no voxels
+	// are clipped
+	Grow bool
+
+	// Wrap moves voxels which leave one side of the canvas back in at the
+	// opposite side, for tiling textures
+	Wrap bool
+}
+
+// Translate moves an object within its canvas. By default voxels moved
+// outside the canvas are lost.
+func Translate(v magica.VoxelObject, opts TranslateOptions) (r magica.VoxelObject, err error) {
+	if opts.Grow && opts.Wrap {
+		return v.Copy(), fmt.Errorf("translate cannot both grow and wrap")
+	}
+
+	axes := []Axis{AxisX, AxisY, AxisZ}
+	size, offset := v.Size, opts.Offset
+
+	if opts.Grow {
+		for _, a := range axes {
+			o := a.of(offset)
+			if o < 0 {
+				// Moving towards the origin leaves the object where it is
+				// on the grown canvas
+				a.set(&size, a.of(size)-o)
+				a.set(&offset, 0)
+			} else {
+				a.set(&size, a.of(size)+o)
+			}
+
+			if s := a.of(size); s > maxTransformSize {
+				return v.Copy(), fmt.Errorf("translated object would be %d voxels across, which is more than %d", s, maxTransformSize)
+			}
+		}
+	}
+
+	r = magica.NewVoxelObject(size, v.PaletteData)
+
+	v.Iterate(func(x, y, z int) {
+		dst := geometry.Point{X: x + offset.X, Y: y + offset.Y, Z: z + offset.Z}
+
+		for _, a := range axes {
+			d, s := a.of(dst), a.of(size)
+			if opts.Wrap {
+				a.set(&dst, ((d%s)+s)%s)
+			} else if d < 0 || d >= s {
+				return
+			}
+		}
+
+		r.Voxels[dst.X][dst.Y][dst.Z] = v.Voxels[x][y][z]
+	})
+
+	return r, nil
+}
+
+// Crop cuts an object down to the given bounding volume. The maximum
+// co-ordinates are exclusive, as for RotateAndTile.
+func Crop(v magica.VoxelObject, bv BoundingVolume) (r magica.VoxelObject, err error) {
+	size := geometry.Point{X: bv.Max.X - bv.Min.X, Y: bv.Max.Y - bv.Min.Y, Z: bv.Max.Z - bv.Min.Z}
+
+	if size.X <= 0 || size.Y <= 0 || size.Z <= 0 {
+		return v.Copy(), fmt.Errorf("crop volume %v-%v is empty", bv.Min, bv.Max)
+	}
+
+	if bv.Min.X < 0 || bv.Min.Y < 0 || bv.Min.Z < 0 ||
+		bv.Max.X > v.Size.X || bv.Max.Y > v.Size.Y || bv.Max.Z > v.Size.Z {
+		return v.Copy(), fmt.Errorf("crop volume %v-%v is outside the object (size %v)", bv.Min, bv.Max, v.Size)
+	}
+
+	r = magica.NewVoxelObject(size, v.PaletteData)
+	r.Iterate(func(x, y, z int) {
+		r.Voxels[x][y][z] = v.Voxels[x+bv.Min.X][y+bv.Min.Y][z+bv.Min.Z]
+	})
+
+	return r, nil
+}
+
+// Trim crops an object to the smallest box containing all of its filled
+// voxels, including mask voxels
+func Trim(v magica.VoxelObject) (r magica.VoxelObject, err error) {
+	bv := BoundingVolume{Min: v.Size}
+
+	v.Iterate(func(x, y, z int) {
+		if v.Voxels[x][y][z] == 0 {
+			return
+		}
+
+		bv.Min = geometry.Point{X: min(bv.Min.X, x), Y: min(bv.Min.Y, y), Z: min(bv.Min.Z, z)}
+		bv.Max = geometry.Point{X: max(bv.Max.X, x+1), Y: max(bv.Max.Y, y+1), Z: max(bv.Max.Z, z+1)}
+	})
+
+	if bv.Max == (geometry.Point{}) {
+		return v.Copy(), fmt.Errorf("object has no filled voxels to trim to")
+	}
+
+	return Crop(v, bv)
+}
+
+// Anchor selects where an object is placed along an axis when padding
+type Anchor string
+
+const (
+	// AnchorCentre places the object in the centre, with any odd voxel of
+	// padding on the maximum side
+	AnchorCentre Anchor = "centre"
+	// AnchorMin places the object against the minimum side
+	AnchorMin Anchor = "min"
+	// AnchorMax places the object against the maximum side
+	AnchorMax Anchor = "max"
+)
+
+func (a Anchor) validate() error {
+	switch a {
+	case "", AnchorCentre, AnchorMin, AnchorMax:
+		return nil
+	}
+
+	return fmt.Errorf("unknown anchor %s", a)
+}
+
+// Anchors gives the anchor for each axis. Empty anchors use AnchorCentre.
+type Anchors struct {
+	X Anchor `json:"x"`
+	Y Anchor `json:"y"`
+	Z Anchor `json:"z"`
+}
+
+// PadOptions controls how Pad grows an object
+type PadOptions struct {
+	// Size is the new size of the canvas. Axes with a size of 0 are left
+	// unchanged.
+	Size geometry.Point
+
+	// Anchor gives where the object is placed within the new canvas along
+	// each axis
+	Anchor Anchors
+}
+
+// Pad grows the canvas of an object to the given size
+func Pad(v magica.VoxelObject, opts PadOptions) (r magica.VoxelObject, err error) {
+	size, offset := v.Size, geometry.Point{}
+
+	for _, a := range []Axis{AxisX, AxisY, AxisZ} {
+		anchor := map[Axis]Anchor{AxisX: opts.Anchor.X, AxisY: opts.Anchor.Y, AxisZ: opts.Anchor.Z}[a]
+		if err := anchor.validate(); err != nil {
+			return v.Copy(), err
+		}
+
+		target := a.of(opts.Size)
+		if target == 0 {
+			continue
+		}
+
+		if target < a.of(v.Size) {
+			return v.Copy(), fmt.Errorf("cannot pad %s axis of size %d to smaller size %d", a, a.of(v.Size), target)
+		}
+
+		if target > maxTransformSize {
+			return v.Copy(), fmt.Errorf("cannot pad %s axis to size %d, which is more than %d", a, target, maxTransformSize)
+		}
+
+		a.set(&size, target)
+
+		switch anchor {
+		case AnchorMin:
+		case AnchorMax:
+			a.set(&offset, target-a.of(v.Size))
+		default:
+			a.set(&offset, (target-a.of(v.Size))/2)
+		}
+	}
+
+	r = magica.NewVoxelObject(size, v.PaletteData)
+	v.Iterate(func(x, y, z int) {
+		r.Voxels[x+offset.X][y+offset.Y][z+offset.Z] = v.Voxels[x][y][z]
+	})
+
+	return r, nil
+}
@@ -0,0 +1,695 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"github.com/mattkimber/cargopositor/internal/utils"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+	"strings"
+)
+
+// ScaleOptions controls how AddScaled composites a cargo object
+type ScaleOptions struct {
+	// InputRamps and OutputRamps recolour the cargo before scaling. Only the
+	// first pair of ramps is used.
+	InputRamps  []string
+	OutputRamps []string
+
+	// Scale sets how much of the source object's original size to preserve in
+	// each dimension, from 0.0 (fill the cargo area) to 1.0 (don't scale)
+	Scale geometry.PointF
+
+	// Overwrite allows the cargo to replace non-mask voxels
+	Overwrite bool
+
+	// IgnoreMask treats the whole object as the cargo area
+	IgnoreMask bool
+
+	// NoMask sets what happens if the object has no mask voxels
+	NoMask MaskPolicy
+
+	// MaskIndex is the colour index of mask voxels. 0 uses DefaultMaskIndex.
+	MaskIndex byte
+
+	// Region limits the cargo area to a single connected region of mask
+	// voxels, numbered as by MaskRegions. 0 uses all mask voxels.
+	Region int
+
+	// MaskOriginal sets all voxels of the original object outside the cargo area to the mask colour
+	MaskOriginal bool
+
+	// MaskNew sets all voxels of the composited cargo to the mask colour
+	MaskNew bool
+
+	// Filter chooses the colour of each output voxel from the source voxels
+	// it covers. Defaults to FilterModal.
+	Filter Filter
+
+	// Priority is the list of colours used by FilterPriority, highest
+	// priority first
+	Priority []byte
+}
+
+// RepeatOptions controls how AddRepeated composites a cargo object
+type RepeatOptions struct {
+	// N limits the number of repeated items. 0 means no limit.
+	N int
+
+	// InputRamps and OutputRamps recolour the cargo. Successive items cycle
+	// through the ramp pairs.
+	InputRamps  []string
+	OutputRamps []string
+
+	// Overwrite allows the cargo to replace non-mask voxels
+	Overwrite bool
+
+	// BlendMode is one of "over" (the default), "in", "out", "atop" or "xor"
+	BlendMode string
+
+	// IgnoreMask treats the whole object as the cargo area
+	IgnoreMask bool
+
+	// NoMask sets what happens if the object has no mask voxels
+	NoMask MaskPolicy
+
+	// MaskIndex is the colour index of mask voxels. 0 uses DefaultMaskIndex.
+	MaskIndex byte
+
+	// Region limits the cargo area to a single connected region of mask
+	// voxels, numbered as by MaskRegions. 0 uses all mask voxels.
+	Region int
+
+	// IgnoreTruncation allows items to be truncated at the edges of the cargo area
+	IgnoreTruncation bool
+
+	// MaskOriginal sets all voxels of the original object outside the cargo area to the mask colour
+	MaskOriginal bool
+
+	// MaskNew sets all voxels of the composited cargo to the mask colour
+	MaskNew bool
+
+	// FlipX composites the cargo flipped in X
+	FlipX bool
+}
+
+// isInsideInclusive returns true if the point is within bounds, including
+// the maximum point
+func isInsideInclusive(b geometry.Bounds, x, y, z int) bool {
+	return x >= b.Min.X && x <= b.Max.X && y >= b.Min.Y && y <= b.Max.Y && z >= b.Min.Z && z <= b.Max.Z
+}
+
+// checkSize returns an error if any dimension of the object is empty
+func checkSize(v *magica.VoxelObject, name string) error {
+	if v.Size.X <= 0 || v.Size.Y <= 0 || v.Size.Z <= 0 {
+		return fmt.Errorf("%s object is empty (size %d x %d x %d)", name, v.Size.X, v.Size.Y, v.Size.Z)
+	}
+	return nil
+}
+
+// iterate calls iterator for every voxel in the object, in the same order
+// as VoxelObject.Iterate. It stops and returns the context's error if the
+// context is cancelled, checking once per X slice.
+func iterate(ctx context.Context, v *magica.VoxelObject, iterator func(x, y, z int)) error {
+	for x := 0; x < v.Size.X; x++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		for y := 0; y < v.Size.Y; y++ {
+			for z := 0; z < v.Size.Z; z++ {
+				iterator(x, y, z)
+			}
+		}
+	}
+
+	return nil
+}
+
+// ProduceEmpty returns the base object without any cargo
+// (remove special voxels). A maskIndex of 0 uses DefaultMaskIndex.
+func ProduceEmpty(v magica.VoxelObject, inputRamps, outputRamps []string, maskIndex byte) (r magica.VoxelObject, err error) {
+	mask := maskOrDefault(maskIndex)
+
+	r = v.Copy()
+
+	if len(inputRamps) > 0 && len(outputRamps) > 0 {
+		if r, err = Recolour(r, inputRamps[0], outputRamps[0]); err != nil {
+			return r, err
+		}
+	}
+
+	iterator := func(x, y, z int) {
+		if r.Voxels[x][y][z] == mask {
+			r.Voxels[x][y][z] = 0
+		}
+	}
+
+	r.Iterate(iterator)
+
+	return r, nil
+}
+
+// Identity returns the base object without any changes at all
+func Identity(v magica.VoxelObject) (r magica.VoxelObject) {
+	r = v.Copy()
+	return r
+}
+
+// RotateAndTile (and tile) the base object
+func RotateAndTile(v magica.VoxelObject, angle float64, xOffset, yOffset int, scale geometry.PointF, boundingVolume BoundingVolume) (r magica.VoxelObject, err error) {
+	radians := (angle * math.Pi) / 180
+
+	// If no bounding volume was supplied default to (0,0,0)-(max, max, max)
+	if (boundingVolume.Max == geometry.Point{}) {
+		boundingVolume.Max = geometry.Point{X: v.Size.X, Y: v.Size.Y, Z: v.Size.Z}
+	}
+
+	// If no scale is supplied default to (1,1,1)
+	if (scale == geometry.PointF{}) {
+		scale = geometry.PointF{X: 1, Y: 1, Z: 1}
+	}
+
+	r = v.Copy()
+
+	bvx := boundingVolume.Max.X - boundingVolume.Min.X
+	bvy := boundingVolume.Max.Y - boundingVolume.Min.Y
+	bvz := boundingVolume.Max.Z - boundingVolume.Min.Z
+
+	if bvx <= 0 || bvy <= 0 || bvz <= 0 {
+		return r, fmt.Errorf("bounding volume %v-%v is empty", boundingVolume.Min, boundingVolume.Max)
+	}
+
+	if boundingVolume.Min.X < 0 || boundingVolume.Min.Y < 0 || boundingVolume.Min.Z < 0 ||
+		boundingVolume.Max.X > v.Size.X || boundingVolume.Max.Y > v.Size.Y || boundingVolume.Max.Z > v.Size.Z {
+		return r, fmt.Errorf("bounding volume %v-%v is outside the object (size %v)", boundingVolume.Min, boundingVolume.Max, v.Size)
+	}
+
+	// Clear the object
+	iterator := func(x, y, z int) {
+		r.Voxels[x][y][z] = 0
+	}
+
+	r.Iterate(iterator)
+
+	// RotateAndTile the output
+	iterator = func(x, y, z int) {
+		sx := ((bvx + xOffset + int((float64(x)*math.Cos(radians)-float64(y)*math.Sin(radians))*scale.X)) % bvx) + boundingVolume.Min.X
+		sy := ((bvy + yOffset + int((float64(x)*math.Sin(radians)+float64(y)*math.Cos(radians))*scale.Y)) % bvy) + boundingVolume.Min.Y
+		sz := ((z + bvz) % bvz) + boundingVolume.Min.Z
+
+		if r.Voxels[x][y][z] == 0 && sx >= 0 && sy >= 0 && sx < v.Size.X && sy < v.Size.Y {
+			r.Voxels[x][y][z] = v.Voxels[sx][sy][sz]
+		}
+	}
+
+	r.Iterate(iterator)
+
+	return r, nil
+}
+
+// Stairstep the base object (for every m steps in x, move n steps in z)
+func Stairstep(v magica.VoxelObject, m float64, n int) (r magica.VoxelObject, err error) {
+	r = v.Copy()
+
+	if m == 0 || math.IsNaN(m) || math.IsInf(m, 0) {
+		return r, fmt.Errorf("invalid x steps %v (must be non-zero)", m)
+	}
+
+	if n < 1 {
+		return r, fmt.Errorf("invalid z steps %d (must be at least 1)", n)
+	}
+
+	// Clear the object
+	iterator := func(x, y, z int) {
+		r.Voxels[x][y][z] = 0
+	}
+
+	r.Iterate(iterator)
+
+	// Stairstep the output
+	iterator = func(x, y, z int) {
+		step := z + int((float64(x)/m)*float64(n))
+		begin := step
+
+		if x > 0 {
+			prevStep := z + int((float64(x-1)/m)*float64(n))
+			if prevStep < step {
+				begin -= n
+			}
+		}
+
+		for s := begin; s < step+n; s++ {
+			if s >= 0 && s < v.Size.Z {
+				if r.Voxels[x][y][s] == 0 {
+					r.Voxels[x][y][s] = v.Voxels[x][y][z]
+				}
+			}
+		}
+	}
+
+	v.Iterate(iterator)
+
+	return r, nil
+}
+
+// validateScale returns an error if any component of a scale is outside
+// the range 0.0-1.0
+func validateScale(scale geometry.PointF) error {
+	for _, c := range []struct {
+		axis  Axis
+		value float64
+	}{{AxisX, scale.X}, {AxisY, scale.Y}, {AxisZ, scale.Z}} {
+		if !(c.value >= 0 && c.value <= 1) {
+			return fmt.Errorf("scale %g for %s axis is not in the range 0.0-1.0", c.value, c.axis)
+		}
+	}
+	return nil
+}
+
+// AddScaled scales a cargo object to the cargo area
+func AddScaled(ctx context.Context, dst magica.VoxelObject, src magica.VoxelObject, opts ScaleOptions) (r magica.VoxelObject, err error) {
+	r = dst.Copy()
+
+	// If there is an input/output ramp, we always use the first one when scaling
+	if len(opts.InputRamps) > 0 && len(opts.OutputRamps) > 0 {
+		if src, err = Recolour(src, opts.InputRamps[0], opts.OutputRamps[0]); err != nil {
+			return r, err
+		}
+	}
+
+	if err = opts.Filter.validate(); err != nil {
+		return r, err
+	}
+
+	if err = validateScale(opts.Scale); err != nil {
+		return r, err
+	}
+
+	if err = checkSize(&src, "source"); err != nil {
+		return r, err
+	}
+
+	scaleLogic, overwrite, ignoreMask := opts.Scale, opts.Overwrite, opts.IgnoreMask
+	maskOriginal, maskNew := opts.MaskOriginal, opts.MaskNew
+
+	mask := maskOrDefault(opts.MaskIndex)
+
+	area, err := getCargoArea(&r, ignoreMask, mask, opts.NoMask, opts.Region)
+	if err != nil {
+		return r, err
+	}
+
+	dstBounds, ignoreMask := area.bounds, area.ignoreMask
+	srcBounds := geometry.Bounds{Min: geometry.Point{}, Max: geometry.Point{X: src.Size.X, Y: src.Size.Y, Z: src.Size.Z}}
+	srcSize, dstSize := srcBounds.GetSize(), dstBounds.GetSize()
+
+	scale := geometry.PointF{
+		X: ((float64(srcSize.X) / float64(dstSize.X+1)) * (1 - scaleLogic.X)) + scaleLogic.X,
+		Y: (float64(srcSize.Y)/float64(dstSize.Y+1))*(1-scaleLogic.Y) + scaleLogic.Y,
+		Z: (float64(srcSize.Z)/float64(dstSize.Z+1))*(1-scaleLogic.Z) + scaleLogic.Z,
+	}
+
+	iterator := func(x, y, z int) {
+		if isInsideInclusive(dstBounds, x, y, z) && ((ignoreMask && r.Voxels[x][y][z] == 0) || area.isMask(&r, x, y, z) || overwrite) {
+			minX := int(math.Floor(float64(x-dstBounds.Min.X) * scale.X))
+			minY := int(math.Floor(float64(y-dstBounds.Min.Y) * scale.Y))
+			minZ := int(math.Floor(float64(z-dstBounds.Min.Z) * scale.Z))
+
+			maxX := int(math.Ceil(float64((x+1)-dstBounds.Min.X) * scale.X))
+			maxY := int(math.Ceil(float64((y+1)-dstBounds.Min.Y) * scale.Y))
+			maxZ := int(math.Ceil(float64((z+1)-dstBounds.Min.Z) * scale.Z))
+
+			var modalIndex byte
+
+			if opts.Filter == FilterNearest {
+				i := int(math.Floor((float64(x-dstBounds.Min.X) + 0.5) * scale.X))
+				j := int(math.Floor((float64(y-dstBounds.Min.Y) + 0.5) * scale.Y))
+				k := int(math.Floor((float64(z-dstBounds.Min.Z) + 0.5) * scale.Z))
+
+				if i >= 0 && j >= 0 && k >= 0 && i < srcBounds.Max.X && j < srcBounds.Max.Y && k < srcBounds.Max.Z {
+					modalIndex = src.Voxels[i][j][k]
+				}
+			} else {
+				values := colourCounts{}
+
+				for i := minX; i < maxX; i++ {
+					for j := minY; j < maxY; j++ {
+						for k := minZ; k < maxZ; k++ {
+
+							if i >= 0 && j >= 0 && k >= 0 && i < srcBounds.Max.X && j < srcBounds.Max.Y && k < srcBounds.Max.Z {
+								values[src.Voxels[i][j][k]]++
+							}
+						}
+					}
+				}
+
+				modalIndex = values.pick(opts.Filter, opts.Priority, r.PaletteData)
+			}
+
+			if maskNew && modalIndex != 0 {
+				modalIndex = mask
+			}
+
+			if !overwrite || modalIndex != 0 {
+				r.Voxels[x][y][z] = modalIndex
+			}
+		} else if maskOriginal && r.Voxels[x][y][z] != 0 {
+			r.Voxels[x][y][z] = mask
+		}
+	}
+
+	err = iterate(ctx, &r, iterator)
+	return r, err
+}
+
+// AddRepeated repeats a cargo object across the cargo area up to n times
+func AddRepeated(ctx context.Context, v magica.VoxelObject, originalSrc magica.VoxelObject, opts RepeatOptions) (r magica.VoxelObject, err error) {
+	r = v.Copy()
+
+	n, inputRamps, outputRamps := opts.N, opts.InputRamps, opts.OutputRamps
+	overwrite, blendMode, ignoreMask, ignoreTruncation := opts.Overwrite, opts.BlendMode, opts.IgnoreMask, opts.IgnoreTruncation
+	maskOriginal, maskNew, flipX := opts.MaskOriginal, opts.MaskNew, opts.FlipX
+
+	if err = checkSize(&originalSrc, "source"); err != nil {
+		return r, err
+	}
+
+	if len(inputRamps) != len(outputRamps) {
+		return r, fmt.Errorf("%d input ramps but %d output ramps", len(inputRamps), len(outputRamps))
+	}
+
+	mask := maskOrDefault(opts.MaskIndex)
+
+	area, err := getCargoArea(&r, ignoreMask, mask, opts.NoMask, opts.Region)
+	if err != nil {
+		return r, err
+	}
+
+	dstBounds, ignoreMask := area.bounds, area.ignoreMask
+	srcBounds := geometry.Bounds{Min: geometry.Point{}, Max: geometry.Point{X: originalSrc.Size.X, Y: originalSrc.Size.Y, Z: originalSrc.Size.Z}}
+	srcSize, dstSize := srcBounds.GetSize(), dstBounds.GetSize()
+
+	lastItem := -1
+	ramps := len(inputRamps)
+
+	// Create all the necessary recolour objects
+	srcObjects := []magica.VoxelObject{originalSrc}
+	if ramps > 0 {
+		srcObjects = make([]magica.VoxelObject, ramps)
+		for idx := range inputRamps {
+			if srcObjects[idx], err = Recolour(originalSrc, inputRamps[idx], outputRamps[idx]); err != nil {
+				return r, err
+			}
+		}
+	} else {
+		ramps = 1
+	}
+
+	items := (dstSize.Y + 1) / srcSize.Y
+	cols := (dstSize.X + 1) / srcSize.X
+	rows := (dstSize.Z + 1) / srcSize.Z
+
+	if !ignoreTruncation && (items == 0 || cols == 0 || rows == 0) {
+		return r, fmt.Errorf("source (size %d x %d x %d) is larger than the cargo area (size %d x %d x %d)",
+			srcSize.X, srcSize.Y, srcSize.Z, dstSize.X+1, dstSize.Y+1, dstSize.Z+1)
+	}
+
+	yOffset := ((dstSize.Y + 1) - (items * srcSize.Y)) / 2
+	xOffset := ((dstSize.X) - (cols * srcSize.X)) / 2
+
+	if ignoreTruncation {
+		yOffset = 0
+		xOffset = 0
+	}
+
+	var src magica.VoxelObject
+
+	iterator := func(x, y, z int) {
+		if isInsideInclusive(dstBounds, x, y, z) && ((ignoreMask && r.Voxels[x][y][z] == 0) || area.isMask(&r, x, y, z) || overwrite) {
+			item := ((y - yOffset) - dstBounds.Min.Y) / srcSize.Y
+			col := (dstBounds.Max.X - (x + (xOffset / 2))) / (srcSize.X + xOffset)
+			row := (z - dstBounds.Min.Z) / srcSize.Z
+
+			if item+(col*items)+(row*cols*rows) != lastItem {
+				// Pick the recolour ramp for this item
+				src = srcObjects[(item+(col*items)+(row*cols*rows))%ramps]
+			}
+
+			lastItem = item + (col * items) + (row * cols * rows)
+
+			sx := srcSize.X - 1 - (((dstBounds.Max.X) - (x + (xOffset / 2))) % (srcSize.X + xOffset))
+
+			if flipX {
+				sx = (x - dstBounds.Min.X) % srcSize.X
+			}
+			sy := (y - (yOffset + dstBounds.Min.Y)) % srcSize.Y
+			sz := (z - dstBounds.Min.Z) % srcSize.Z
+
+			if (n == 0 || overwrite || item+(col*items)+(row*cols*rows) < n) && ((n == 0 && ignoreTruncation) || (item < items && col < cols && row < rows)) && (y-dstBounds.Min.Y) >= yOffset {
+				if sx < 0 || sx >= srcSize.X {
+					r.Voxels[x][y][z] = 0
+				} else {
+					if !overwrite || src.Voxels[sx][sy][sz] != 0 || blendMode == "in" || blendMode == "out" {
+						if maskNew {
+							if src.Voxels[sx][sy][sz] == 0 {
+								r.Voxels[x][y][z] = 0
+							} else {
+								r.Voxels[x][y][z] = mask
+							}
+						} else {
+							if blendMode == "in" {
+								if r.Voxels[x][y][z] != 0 && src.Voxels[sx][sy][sz] != 0 {
+									r.Voxels[x][y][z] = src.Voxels[sx][sy][sz]
+								} else {
+									r.Voxels[x][y][z] = 0
+								}
+							} else if blendMode == "out" {
+								if r.Voxels[x][y][z] == 0 && src.Voxels[sx][sy][sz] != 0 {
+									r.Voxels[x][y][z] = src.Voxels[sx][sy][sz]
+								} else {
+									r.Voxels[x][y][z] = 0
+								}
+							} else if blendMode == "atop" {
+								if r.Voxels[x][y][z] != 0 {
+									r.Voxels[x][y][z] = src.Voxels[sx][sy][sz]
+								}
+							} else if blendMode == "xor" {
+								if r.Voxels[x][y][z] != 0 {
+									r.Voxels[x][y][z] = 0
+								} else {
+									r.Voxels[x][y][z] = src.Voxels[sx][sy][sz]
+								}
+							} else {
+								// default to "over" mode
+								r.Voxels[x][y][z] = src.Voxels[sx][sy][sz]
+							}
+						}
+					}
+				}
+			} else if !overwrite {
+				r.Voxels[x][y][z] = 0
+			}
+		} else if r.Voxels[x][y][z] != 0 && maskOriginal {
+			r.Voxels[x][y][z] = mask
+		}
+	}
+
+	err = iterate(ctx, &r, iterator)
+	return r, err
+}
+
+// Remove one voxel object from another (or clip against a colour).
+// Voxels are kept only where the source voxel is the given index, so an
+// index of 0 removes the source and the mask index clips to it.
+func Remove(v magica.VoxelObject, src magica.VoxelObject, index uint8) (r magica.VoxelObject) {
+	r = v.Copy()
+
+	iterator := func(x, y, z int) {
+		if x < src.Size.X && y < src.Size.Y && z < src.Size.Z && src.Voxels[x][y][z] != index {
+			r.Voxels[x][y][z] = 0
+		}
+	}
+
+	r.Iterate(iterator)
+
+	return r
+}
+
+// Ramp is a single parsed input to output colour mapping
+type Ramp struct {
+	InputLength      float64
+	OutputLength     float64
+	StartIndex       int
+	EndIndex         int
+	OutputStartIndex int
+}
+
+// parseRamps parses a pair of input/output ramp specifications. Empty
+// specifications produce no ramps.
+func parseRamps(inputRamp, outputRamp string) ([]Ramp, error) {
+	if inputRamp == "" || outputRamp == "" {
+		return nil, nil
+	}
+
+	// Deal with the old GoRender format
+	if !strings.ContainsRune(inputRamp, '-') && !strings.ContainsRune(outputRamp, '-') {
+		inputRamp = strings.Replace(inputRamp, ",", "-", -1)
+		outputRamp = strings.Replace(outputRamp, ",", "-", -1)
+	}
+
+	inputRamps := strings.Split(inputRamp, ",")
+	outputRamps := strings.Split(outputRamp, ",")
+
+	if len(inputRamps) != len(outputRamps) {
+		return nil, fmt.Errorf("invalid colour remap specification %s/%s (ramp counts don't match)", inputRamp, outputRamp)
+	}
+
+	ramps := make([]Ramp, len(inputRamps))
+
+	for idx := range inputRamps {
+		inputs, outputs := utils.SplitAndParseToInt(inputRamps[idx]), utils.SplitAndParseToInt(outputRamps[idx])
+
+		if len(inputs) < 2 || len(outputs) < 2 {
+			return nil, fmt.Errorf("invalid colour remap specification %s/%s (invalid ramp length)", inputRamps[idx], outputRamps[idx])
+		}
+
+		for _, c := range append(inputs[:2:2], outputs[:2]...) {
+			if c < 0 || c > 255 {
+				return nil, fmt.Errorf("invalid colour remap specification %s/%s (colour %d is not in the range 0-255)", inputRamps[idx], outputRamps[idx], c)
+			}
+		}
+
+		if inputs[1] < inputs[0] {
+			return nil, fmt.Errorf("invalid colour remap specification %s/%s (input ramp runs backwards)", inputRamps[idx], outputRamps[idx])
+		}
+
+		ramps[idx] = Ramp{
+			InputLength:      float64(inputs[1] - inputs[0]),
+			OutputLength:     float64(outputs[1] - outputs[0]),
+			StartIndex:       inputs[0],
+			EndIndex:         inputs[1],
+			OutputStartIndex: outputs[0],
+		}
+	}
+
+	return ramps, nil
+}
+
+// Recolour according to input/output ramps
+func Recolour(v magica.VoxelObject, inputRamp, outputRamp string) (r magica.VoxelObject, err error) {
+	r = v.Copy()
+
+	ramps, err := parseRamps(inputRamp, outputRamp)
+	if err != nil {
+		return r, err
+	}
+
+	iterator := func(x, y, z int) {
+		c := r.Voxels[x][y][z]
+		for _, rmp := range ramps {
+			if c >= byte(rmp.StartIndex) && c <= byte(rmp.EndIndex) {
+				output := rmp.OutputStartIndex
+				if rmp.InputLength > 0 {
+					output += int(math.Round((float64(int(c)-rmp.StartIndex) / rmp.InputLength) * rmp.OutputLength))
+				}
+				r.Voxels[x][y][z] = byte(output)
+
+				// Only apply the first ramp we find (don't repeatedly map colours)
+				break
+			}
+		}
+	}
+
+	r.Iterate(iterator)
+
+	return r, nil
+}
+
+// RotateY Rotates an object around its Y axis
+func RotateY(v magica.VoxelObject, angle float64) (r magica.VoxelObject) {
+	return rotatePlane(v, AxisX, AxisZ, angle, PivotCentre)
+}
+
+// RotateZ rotates an object from the bottom. Despite the name this rotates
+// in the Y-Z plane, i.e. around the X axis, and is the same as RotateX with
+// PivotBottom. It is kept for compatibility with existing batches.
+func RotateZ(v magica.VoxelObject, angle float64) (r magica.VoxelObject) {
+	return rotatePlane(v, AxisY, AxisZ, angle, PivotBottom)
+}
+
+// RotateX rotates an object around its X axis
+func RotateX(v magica.VoxelObject, angle float64, pivot Pivot) (r magica.VoxelObject, err error) {
+	return RotateAbout(v, AxisX, angle, pivot)
+}
+
+// RotateAbout rotates an object around the given axis. The canvas is
+// resized to fit the rotated object.
+func RotateAbout(v magica.VoxelObject, axis Axis, angle float64, pivot Pivot) (r magica.VoxelObject, err error) {
+	if err := pivot.validate(); err != nil {
+		return v.Copy(), err
+	}
+
+	a, b, err := planeAxes(axis)
+	if err != nil {
+		return v.Copy(), err
+	}
+
+	if pivot == PivotBottom && b != AxisZ {
+		return v.Copy(), fmt.Errorf("bottom pivot cannot be used for rotation around the Z axis")
+	}
+
+	return rotatePlane(v, a, b, angle, pivot), nil
+}
+
+// rotatePlane rotates an object in the plane of axes a and b, sampling each
+// output voxel from the input. With PivotBottom, b is measured from 0 rather
+// than the centre, so the bottom of the object stays in place.
+func rotatePlane(v magica.VoxelObject, a, b Axis, angle float64, pivot Pivot) (r magica.VoxelObject) {
+	sin, cos := math.Sin(degToRad(angle)), math.Cos(degToRad(angle))
+
+	orgMidpointA := float64(a.of(v.Size)) / 2
+	orgMidpointB := float64(b.of(v.Size)) / 2
+
+	aVector := (orgMidpointA * math.Abs(cos)) + (orgMidpointB * math.Abs(sin))
+	bVector := (orgMidpointA * math.Abs(sin)) + (orgMidpointB * math.Abs(cos))
+
+	size := v.Size
+	a.set(&size, int(math.Ceil(aVector*2)))
+	b.set(&size, int(math.Ceil(bVector*2)))
+
+	r = magica.NewVoxelObject(size, v.PaletteData)
+
+	iterator := func(x, y, z int) {
+		p := geometry.Point{X: x, Y: y, Z: z}
+
+		fda := float64(a.of(p)) - (float64(a.of(r.Size)) / 2)
+		fdb := float64(b.of(p))
+		if pivot != PivotBottom {
+			fdb -= float64(b.of(r.Size)) / 2
+		}
+
+		fda, fdb = (fda*cos)+(fdb*sin), (fda*-sin)+(fdb*cos)
+
+		da := int(math.Ceil(fda + orgMidpointA))
+		db := int(math.Ceil(fdb))
+		if pivot != PivotBottom {
+			db = int(math.Ceil(fdb + orgMidpointB))
+		}
+
+		if da >= 0 && db >= 0 && da < a.of(v.Size) && db < b.of(v.Size) {
+			a.set(&p, da)
+			b.set(&p, db)
+			r.Voxels[x][y][z] = v.Voxels[p.X][p.Y][p.Z]
+		}
+	}
+
+	r.Iterate(iterator)
+
+	return r
+}
+
+func degToRad(angle float64) float64 {
+	return (angle / 180.0) * math.Pi
+}
@@ -0,0 +1,1501 @@
+package compositor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/mattkimber/cargopositor/internal/utils"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func Test_getBounds(t *testing.T) {
+	testCases := []struct {
+		filename string
+		expected geometry.Bounds
+	}{
+		{
+			filename: "testdata/example_input.vox",
+			expected: geometry.Bounds{
+				Min: geometry.Point{X: 2, Y: 2, Z: 5},
+				Max: geometry.Point{X: 17, Y: 7, Z: 9},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		object, err := magica.FromFile(tc.filename)
+		if err != nil {
+			t.Errorf("Could not read object: %v", err)
+		}
+
+		bounds, err := getBounds(&object, false, DefaultMaskIndex)
+		if err != nil {
+			t.Errorf("Could not get bounds: %v", err)
+		}
+		if bounds != tc.expected {
+			t.Errorf("Object %s expected bounds %v, got %v", tc.filename, tc.expected, bounds)
+		}
+	}
+}
+
+func TestAddScaledWithSize(t *testing.T) {
+	src, err := magica.FromFile("testdata/2by2.vox")
+	if err != nil {
+		t.Errorf("Could not read object: %v", err)
+	}
+
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := AddScaled(context.Background(), v, src, ScaleOptions{InputRamps: []string{"2,16"}, OutputRamps: []string{"72,79"}, Scale: geometry.PointF{X: 1.0, Z: 1.0}})
+		if err != nil {
+			t.Errorf("Could not scale object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/not_scaled.vox")
+}
+
+func TestAddScaled(t *testing.T) {
+	src, err := magica.FromFile("testdata/example_cargo.vox")
+	if err != nil {
+		t.Errorf("Could not read object: %v", err)
+	}
+
+	// "2-16" and "72,79" have different numbers of ramps. This used to log
+	// a warning and scale the cargo without recolouring it, and is now an
+	// error.
+	opts := ScaleOptions{InputRamps: []string{"2-16"}, OutputRamps: []string{"72,79"}}
+
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		if _, err := AddScaled(context.Background(), v, src, opts); err == nil || !strings.Contains(err.Error(), "ramp counts don't match") {
+			t.Errorf("Expected ramp count error, got %v", err)
+		}
+
+		// The expected output is unchanged from when the ramps were ignored
+		r, err := AddScaled(context.Background(), v, src, ScaleOptions{})
+		if err != nil {
+			t.Errorf("Could not scale object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/scaled.vox")
+}
+
+func TestAddScaledFilters(t *testing.T) {
+	// Four source voxels scaled into a single destination voxel, with
+	// colours 5 and 3 tied for the most common
+	dst := magica.NewVoxelObject(geometry.Point{X: 1, Y: 1, Z: 1}, nil)
+	dst.Voxels[0][0][0] = 255
+
+	src := magica.NewVoxelObject(geometry.Point{X: 4, Y: 1, Z: 1}, nil)
+	src.Voxels[0][0][0], src.Voxels[1][0][0], src.Voxels[2][0][0], src.Voxels[3][0][0] = 5, 3, 3, 5
+
+	testCases := []struct {
+		filter   Filter
+		priority []byte
+		expected byte
+	}{
+		{"", nil, 3},
+		{FilterModal, nil, 3},
+		{FilterNearest, nil, 3},
+		{FilterDarkest, nil, 3},
+		{FilterLightest, nil, 5},
+		{FilterPriority, []byte{9, 5}, 5},
+		{FilterPriority, []byte{9}, 3},
+	}
+
+	for _, tc := range testCases {
+		// Repeat to make sure ties are not broken by map ordering
+		for i := 0; i < 20; i++ {
+			r, err := AddScaled(context.Background(), dst, src, ScaleOptions{Filter: tc.filter, Priority: tc.priority})
+			if err != nil {
+				t.Fatalf("Could not scale object: %v", err)
+			}
+
+			if c := r.Voxels[0][0][0]; c != tc.expected {
+				t.Fatalf("Filter %q with priority %v: expected %d, got %d", tc.filter, tc.priority, tc.expected, c)
+			}
+		}
+	}
+
+	if _, err := AddScaled(context.Background(), dst, src, ScaleOptions{Filter: "bicubic"}); err == nil {
+		t.Errorf("Expected error for unknown filter")
+	}
+
+	// With a palette, brightness is used rather than index order: here 5 is
+	// darker than 3
+	dst.PaletteData = greyPalette(map[byte]byte{3: 200, 5: 40}, 100)
+	for filter, expected := range map[Filter]byte{FilterDarkest: 5, FilterLightest: 3} {
+		r, err := AddScaled(context.Background(), dst, src, ScaleOptions{Filter: filter})
+		if err != nil {
+			t.Fatalf("Could not scale object: %v", err)
+		}
+
+		if c := r.Voxels[0][0][0]; c != expected {
+			t.Errorf("Filter %q with palette: expected %d, got %d", filter, expected, c)
+		}
+	}
+}
+
+func TestAddScaledInvalidScale(t *testing.T) {
+	dst := magica.NewVoxelObject(geometry.Point{X: 10, Y: 10, Z: 10}, nil)
+	dst.Iterate(func(x, y, z int) { dst.Voxels[x][y][z] = 255 })
+
+	src := magica.NewVoxelObject(geometry.Point{X: 22, Y: 22, Z: 22}, nil)
+	src.Iterate(func(x, y, z int) { src.Voxels[x][y][z] = 5 })
+
+	for _, scale := range []geometry.PointF{{X: 2, Y: 2, Z: 2}, {Y: -0.5}, {Z: math.NaN()}} {
+		for _, filter := range []Filter{FilterModal, FilterNearest} {
+			if _, err := AddScaled(context.Background(), dst, src, ScaleOptions{Scale: scale, Filter: filter}); err == nil {
+				t.Errorf("Expected error for scale %v with filter %s", scale, filter)
+			}
+		}
+	}
+}
+
+func TestAddScaledLargeObjects(t *testing.T) {
+	// Objects wider than 256 voxels are saved as multiple MagicaVoxel models,
+	// so round-trip through a file to test multi-model inputs
+	dst := roundTrip(t, largeObject(geometry.Point{X: 300, Y: 4, Z: 4}, func(x, y, z int) byte { return 255 }))
+	src := roundTrip(t, largeObject(geometry.Point{X: 600, Y: 4, Z: 4}, func(x, y, z int) byte { return byte(1 + (x/2)%200) }))
+
+	if dst.Size.X != 300 || src.Size.X != 600 {
+		t.Fatalf("Multi-model objects were not read at full size: got %v and %v", dst.Size, src.Size)
+	}
+
+	r, err := AddScaled(context.Background(), dst, src, ScaleOptions{})
+	if err != nil {
+		t.Fatalf("Could not scale object: %v", err)
+	}
+
+	r.Iterate(func(x, y, z int) {
+		if expected := byte(1 + x%200); r.Voxels[x][y][z] != expected {
+			t.Fatalf("Expected colour %d at (%d,%d,%d), got %d", expected, x, y, z, r.Voxels[x][y][z])
+		}
+	})
+}
+
+func TestAddRepeatedLargeObjects(t *testing.T) {
+	dst := roundTrip(t, largeObject(geometry.Point{X: 520, Y: 2, Z: 2}, func(x, y, z int) byte { return 255 }))
+	src := largeObject(geometry.Point{X: 260, Y: 2, Z: 2}, func(x, y, z int) byte { return byte(1 + x%250) })
+
+	r, err := AddRepeated(context.Background(), dst, src, RepeatOptions{})
+	if err != nil {
+		t.Fatalf("Could not repeat object: %v", err)
+	}
+
+	r.Iterate(func(x, y, z int) {
+		if expected := byte(1 + (x%260)%250); r.Voxels[x][y][z] != expected {
+			t.Fatalf("Expected colour %d at (%d,%d,%d), got %d", expected, x, y, z, r.Voxels[x][y][z])
+		}
+	})
+}
+
+func largeObject(size geometry.Point, colour func(x, y, z int) byte) magica.VoxelObject {
+	v := magica.NewVoxelObject(size, nil)
+	v.Iterate(func(x, y, z int) { v.Voxels[x][y][z] = colour(x, y, z) })
+	return v
+}
+
+func roundTrip(t *testing.T, v magica.VoxelObject) magica.VoxelObject {
+	buf := bytes.Buffer{}
+	if err := v.Save(&buf); err != nil {
+		t.Fatalf("Could not save object: %v", err)
+	}
+
+	r, err := magica.GetFromReader(&buf, []int{})
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	return r
+}
+
+func TestAddRepeated(t *testing.T) {
+	testAddRepeatedInner(t, 2, "testdata/example_small.vox", "testdata/repeated_small.vox", "testdata/example_input.vox", false, false)
+	testAddRepeatedInner(t, 6, "testdata/example_tiny.vox", "testdata/repeated_tiny.vox", "testdata/example_input.vox", false, false)
+	testAddRepeatedInner(t, 0, "testdata/example_tiny.vox", "testdata/repeated_tiny_no_limit.vox", "testdata/example_input.vox", false, false)
+	testAddRepeatedInner(t, 1, "testdata/example_centred.vox", "testdata/repeated_tiny_centred.vox", "testdata/example_input.vox", false, false)
+}
+
+func TestIgnoreMask(t *testing.T) {
+	testAddRepeatedInner(t, 0, "testdata/no_mask_b.vox", "testdata/no_mask.vox", "testdata/no_mask_a.vox", true, true)
+	testAddRepeatedInner(t, 0, "testdata/no_mask_b.vox", "testdata/no_mask_tiny_1.vox", "testdata/example_tiny.vox", true, true)
+	testAddRepeatedInner(t, 0, "testdata/example_tiny.vox", "testdata/no_mask_tiny_2.vox", "testdata/no_mask_a.vox", true, true)
+}
+
+func testAddRepeatedInner(t *testing.T, n int, input, expected string, inputFilename string, ignoreMask bool, ignoreTruncate bool) {
+	src, err := magica.FromFile(input)
+	if err != nil {
+		t.Errorf("Could not read object: %v", err)
+	}
+
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		opts := RepeatOptions{
+			N:                n,
+			InputRamps:       []string{"2-16", "254-255"},
+			OutputRamps:      []string{"72-79", "1-7"},
+			IgnoreMask:       ignoreMask,
+			IgnoreTruncation: ignoreTruncate,
+		}
+		r, err := AddRepeated(context.Background(), v, src, opts)
+		if err != nil {
+			t.Errorf("Could not repeat object: %v", err)
+		}
+		return r
+	}
+	testOperationWithInputFilename(t, fn, expected, inputFilename)
+}
+
+func TestRecolour(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Recolour(v, "2,16", "72,79")
+		if err != nil {
+			t.Errorf("Could not recolour object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/recolour.vox")
+}
+
+func TestRecolourInvalidRamps(t *testing.T) {
+	v := magica.NewVoxelObject(geometry.Point{X: 1, Y: 1, Z: 1}, nil)
+
+	if _, err := Recolour(v, "2-16,20-30", "72-79"); err == nil {
+		t.Errorf("Expected error for mismatched ramp counts")
+	}
+
+	if _, err := Recolour(v, "2-16", "72"); err == nil {
+		t.Errorf("Expected error for invalid ramp length")
+	}
+}
+
+func TestProduceEmpty(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := ProduceEmpty(v, nil, nil, 0)
+		if err != nil {
+			t.Errorf("Could not produce empty object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/produce_empty.vox")
+}
+
+func TestMaskIndex(t *testing.T) {
+	object := magica.NewVoxelObject(geometry.Point{X: 2, Y: 1, Z: 1}, nil)
+	object.Voxels[0][0][0], object.Voxels[1][0][0] = 200, 255
+
+	src := magica.NewVoxelObject(geometry.Point{X: 1, Y: 1, Z: 1}, nil)
+	src.Voxels[0][0][0] = 7
+
+	empty, err := ProduceEmpty(object, nil, nil, 200)
+	if err != nil {
+		t.Fatalf("Could not produce empty object: %v", err)
+	}
+
+	if empty.Voxels[0][0][0] != 0 || empty.Voxels[1][0][0] != 255 {
+		t.Errorf("Expected only mask index 200 to be removed, got %v", empty.Voxels)
+	}
+
+	scaled, err := AddScaled(context.Background(), object, src, ScaleOptions{MaskIndex: 200})
+	if err != nil {
+		t.Fatalf("Could not scale object: %v", err)
+	}
+
+	if scaled.Voxels[0][0][0] != 7 || scaled.Voxels[1][0][0] != 255 {
+		t.Errorf("Expected only mask index 200 to be replaced, got %v", scaled.Voxels)
+	}
+
+	repeated, err := AddRepeated(context.Background(), object, src, RepeatOptions{MaskIndex: 200, MaskOriginal: true})
+	if err != nil {
+		t.Fatalf("Could not repeat object: %v", err)
+	}
+
+	if repeated.Voxels[0][0][0] != 7 || repeated.Voxels[1][0][0] != 200 {
+		t.Errorf("Expected cargo in mask index 200 and original masked to 200, got %v", repeated.Voxels)
+	}
+}
+
+// twoHolds returns an object with two separate 2x2x2 mask regions
+func twoHolds() magica.VoxelObject {
+	v := magica.NewVoxelObject(geometry.Point{X: 6, Y: 2, Z: 2}, nil)
+	v.Iterate(func(x, y, z int) {
+		if x < 2 || x > 3 {
+			v.Voxels[x][y][z] = 255
+		} else {
+			v.Voxels[x][y][z] = 1
+		}
+	})
+	return v
+}
+
+func TestMaskRegions(t *testing.T) {
+	v := twoHolds()
+
+	regions := MaskRegions(&v, 0)
+	expected := []geometry.Bounds{
+		geometry.NewBounds(0, 0, 0, 1, 1, 1),
+		geometry.NewBounds(4, 0, 0, 5, 1, 1),
+	}
+
+	if !reflect.DeepEqual(regions, expected) {
+		t.Errorf("Expected regions %v, got %v", expected, regions)
+	}
+}
+
+func TestAddRepeatedRegion(t *testing.T) {
+	v := twoHolds()
+	src := magica.NewVoxelObject(geometry.Point{X: 1, Y: 1, Z: 1}, nil)
+	src.Voxels[0][0][0] = 7
+
+	r, err := AddRepeated(context.Background(), v, src, RepeatOptions{Region: 2})
+	if err != nil {
+		t.Fatalf("Could not repeat object: %v", err)
+	}
+
+	r.Iterate(func(x, y, z int) {
+		expected := byte(1)
+		if x < 2 {
+			expected = 255
+		} else if x > 3 {
+			expected = 7
+		}
+
+		if r.Voxels[x][y][z] != expected {
+			t.Fatalf("Expected %d at (%d,%d,%d), got %d", expected, x, y, z, r.Voxels[x][y][z])
+		}
+	})
+
+	if _, err := AddRepeated(context.Background(), v, src, RepeatOptions{Region: 3}); err == nil {
+		t.Errorf("Expected error for missing region")
+	}
+}
+
+func TestIdentity(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject { return Identity(v) }
+	testOperation(t, fn, "testdata/identity.vox")
+}
+
+func TestLayers(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject { return Identity(v) }
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_0_output.vox", "testdata/example_input_layers.vox", []int{0})
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_1_output.vox", "testdata/example_input_layers.vox", []int{1})
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_2_output.vox", "testdata/example_input_layers.vox", []int{2})
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_3_output.vox", "testdata/example_input_layers.vox", []int{3})
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_4_output.vox", "testdata/example_input_layers.vox", []int{4})
+
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_012_output.vox", "testdata/example_input_layers.vox", []int{0, 1, 2})
+	testOperationWithInputFilenameAndLayers(t, fn, "testdata/layer_023_output.vox", "testdata/example_input_layers.vox", []int{0, 2, 3})
+}
+
+func TestStairstep(t *testing.T) {
+	fn := stairstep(t, 4, 1)
+	testOperationWithInputFilename(t, fn, "testdata/stairstep_output.vox", "testdata/stairstep.vox")
+
+	fn = stairstep(t, 2, 1)
+	testOperationWithInputFilename(t, fn, "testdata/stairstep_output_2.vox", "testdata/stairstep.vox")
+
+	fn = stairstep(t, 1, 3)
+	testOperationWithInputFilename(t, fn, "testdata/stairstep_output_3.vox", "testdata/stairstep.vox")
+}
+
+func stairstep(t *testing.T, m float64, n int) func(v magica.VoxelObject) magica.VoxelObject {
+	return func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Stairstep(v, m, n)
+		if err != nil {
+			t.Errorf("Could not stairstep object: %v", err)
+		}
+		return r
+	}
+}
+
+func TestPreconditions(t *testing.T) {
+	object := magica.NewVoxelObject(geometry.Point{X: 4, Y: 4, Z: 4}, nil)
+	object.Voxels[1][1][1] = 1
+
+	masked := object.Copy()
+	masked.Voxels[2][2][2] = 255
+
+	src := magica.NewVoxelObject(geometry.Point{X: 2, Y: 2, Z: 2}, nil)
+	large := magica.NewVoxelObject(geometry.Point{X: 8, Y: 1, Z: 1}, nil)
+	empty := magica.NewVoxelObject(geometry.Point{}, nil)
+
+	testCases := []struct {
+		name     string
+		fn       func() error
+		expected string
+	}{
+		{"rotate with flat bounding volume", func() error {
+			_, err := RotateAndTile(object, 45, 0, 0, geometry.PointF{}, BoundingVolume{Max: geometry.Point{X: 4, Y: 4}})
+			return err
+		}, "empty"},
+		{"rotate with bounding volume outside object", func() error {
+			_, err := RotateAndTile(object, 45, 0, 0, geometry.PointF{}, BoundingVolume{Max: geometry.Point{X: 8, Y: 4, Z: 4}})
+			return err
+		}, "outside"},
+		{"stairstep with zero x steps", func() error {
+			_, err := Stairstep(object, 0, 1)
+			return err
+		}, "x steps"},
+		{"stairstep with zero z steps", func() error {
+			_, err := Stairstep(object, 1, 0)
+			return err
+		}, "z steps"},
+		{"scale with no mask", func() error {
+			_, err := AddScaled(context.Background(), object, src, ScaleOptions{})
+			return err
+		}, "no mask"},
+		{"scale with empty source", func() error {
+			_, err := AddScaled(context.Background(), masked, empty, ScaleOptions{})
+			return err
+		}, "empty"},
+		{"repeat with no mask", func() error {
+			_, err := AddRepeated(context.Background(), object, src, RepeatOptions{})
+			return err
+		}, "no mask"},
+		{"repeat with empty source", func() error {
+			_, err := AddRepeated(context.Background(), masked, empty, RepeatOptions{})
+			return err
+		}, "empty"},
+		{"repeat with source larger than cargo area", func() error {
+			_, err := AddRepeated(context.Background(), masked, large, RepeatOptions{})
+			return err
+		}, "larger than the cargo area"},
+		{"repeat with mismatched ramps", func() error {
+			_, err := AddRepeated(context.Background(), masked, src, RepeatOptions{InputRamps: []string{"1-2"}})
+			return err
+		}, "ramps"},
+		{"recolour with out of range colour", func() error {
+			_, err := Recolour(object, "1-300", "1-2")
+			return err
+		}, "range"},
+		{"recolour with backwards ramp", func() error {
+			_, err := Recolour(object, "10-2", "1-2")
+			return err
+		}, "backwards"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := tc.fn()
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
+
+func TestRotateAndTile(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := RotateAndTile(v, 45, -10, 0, geometry.PointF{X: 1.0, Y: 1.0}, BoundingVolume{})
+		if err != nil {
+			t.Errorf("Could not rotate object: %v", err)
+		}
+		return r
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_45.vox", "testdata/rotate_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := RotateAndTile(v, -30, 5, 0, geometry.PointF{X: 1.0, Y: 1.0}, BoundingVolume{})
+		if err != nil {
+			t.Errorf("Could not rotate object: %v", err)
+		}
+		return r
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_30.vox", "testdata/rotate_input.vox")
+
+}
+
+func TestRotateY(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateY(v, 0)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_y_output_0.vox", "testdata/rotate_y_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateY(v, 5)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_y_output_5.vox", "testdata/rotate_y_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateY(v, 45)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_y_output_45.vox", "testdata/rotate_y_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateY(v, -30)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_y_output_30.vox", "testdata/rotate_y_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateY(v, 90)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_y_output_90.vox", "testdata/rotate_y_input.vox")
+
+}
+
+func TestRotateZ(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateZ(v, 0)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_z_output_0.vox", "testdata/rotate_z_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateZ(v, 5)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_z_output_5.vox", "testdata/rotate_z_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateZ(v, 45)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_z_output_45.vox", "testdata/rotate_z_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateZ(v, -30)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_z_output_30.vox", "testdata/rotate_z_input.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return RotateZ(v, 90)
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_z_output_90.vox", "testdata/rotate_z_input.vox")
+
+}
+
+func TestRotateX(t *testing.T) {
+	for _, angle := range []float64{30, -45, 90} {
+		fn := func(v magica.VoxelObject) magica.VoxelObject {
+			r, err := RotateX(v, angle, PivotCentre)
+			if err != nil {
+				t.Errorf("Could not rotate object: %v", err)
+			}
+			return r
+		}
+		testOperationWithInputFilename(t, fn, fmt.Sprintf("testdata/rotate_x_output_%.0f.vox", math.Abs(angle)), "testdata/rotate_z_input.vox")
+	}
+
+	v, err := magica.FromFile("testdata/rotate_z_input.vox")
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	// RotateZ has always been a rotation around X from the bottom
+	r, err := RotateX(v, 30, PivotBottom)
+	if err != nil {
+		t.Fatalf("Could not rotate object: %v", err)
+	}
+
+	if !reflect.DeepEqual(r, RotateZ(v, 30)) {
+		t.Errorf("Expected bottom pivot to match RotateZ")
+	}
+
+	if _, err := RotateX(v, 30, Pivot("top")); err == nil {
+		t.Errorf("Expected error for unknown pivot")
+	}
+
+	for _, angle := range []float64{30, -45} {
+		fn := func(v magica.VoxelObject) magica.VoxelObject {
+			r, err := RotateAbout(v, AxisZ, angle, PivotCentre)
+			if err != nil {
+				t.Errorf("Could not rotate object: %v", err)
+			}
+			return r
+		}
+		testOperationWithInputFilename(t, fn, fmt.Sprintf("testdata/rotate_axis_z_output_%.0f.vox", math.Abs(angle)), "testdata/rotate_z_input.vox")
+	}
+
+	if _, err := RotateAbout(v, AxisZ, 30, PivotBottom); err == nil {
+		t.Errorf("Expected error for bottom pivot around Z")
+	}
+}
+
+func TestQuarterTurn(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := QuarterTurn(v, AxisZ, 1)
+		if err != nil {
+			t.Errorf("Could not rotate object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/quarter_turn_z.vox")
+
+	v := magica.NewVoxelObject(geometry.Point{X: 4, Y: 2, Z: 6}, nil)
+	v.Voxels[3][1][0], v.Voxels[0][0][5] = 10, 255
+
+	testCases := []struct {
+		axis     Axis
+		turns    int
+		size     geometry.Point
+		expected map[geometry.Point]byte
+	}{
+		{AxisY, 1, geometry.Point{X: 6, Y: 2, Z: 4}, map[geometry.Point]byte{{X: 5, Y: 1, Z: 3}: 10, {X: 0, Y: 0, Z: 0}: 255}},
+		{AxisY, -1, geometry.Point{X: 6, Y: 2, Z: 4}, map[geometry.Point]byte{{X: 0, Y: 1, Z: 0}: 10, {X: 5, Y: 0, Z: 3}: 255}},
+		{AxisX, 2, geometry.Point{X: 4, Y: 2, Z: 6}, map[geometry.Point]byte{{X: 3, Y: 0, Z: 5}: 10, {X: 0, Y: 1, Z: 0}: 255}},
+		{AxisZ, 4, geometry.Point{X: 4, Y: 2, Z: 6}, map[geometry.Point]byte{{X: 3, Y: 1, Z: 0}: 10, {X: 0, Y: 0, Z: 5}: 255}},
+	}
+
+	for _, tc := range testCases {
+		r, err := QuarterTurn(v, tc.axis, tc.turns)
+		if err != nil {
+			t.Fatalf("Could not rotate object: %v", err)
+		}
+
+		if r.Size != tc.size {
+			t.Errorf("%s x%d: expected size %v, got %v", tc.axis, tc.turns, tc.size, r.Size)
+			continue
+		}
+
+		for p, c := range tc.expected {
+			if r.Voxels[p.X][p.Y][p.Z] != c {
+				t.Errorf("%s x%d: expected %d at %v, got %d", tc.axis, tc.turns, c, p, r.Voxels[p.X][p.Y][p.Z])
+			}
+		}
+	}
+}
+
+func TestPermute(t *testing.T) {
+	v := magica.NewVoxelObject(geometry.Point{X: 4, Y: 2, Z: 6}, nil)
+	v.Voxels[3][1][5] = 10
+
+	r, err := Permute(v, []Axis{AxisZ, AxisX, AxisY})
+	if err != nil {
+		t.Fatalf("Could not permute object: %v", err)
+	}
+
+	if expected := (geometry.Point{X: 6, Y: 4, Z: 2}); r.Size != expected {
+		t.Errorf("Expected size %v, got %v", expected, r.Size)
+	}
+
+	if r.Voxels[5][3][1] != 10 {
+		t.Errorf("Expected voxel to be moved to (5,3,1)")
+	}
+
+	for _, order := range [][]Axis{{AxisX, AxisY}, {AxisX, AxisX, AxisZ}, {AxisX, AxisY, "w"}} {
+		if _, err := Permute(v, order); err == nil {
+			t.Errorf("Expected error for axis order %v", order)
+		}
+	}
+}
+
+func TestRotate3D(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Rotate3D(context.Background(), v, Rotate3DOptions{Euler: geometry.PointF{Y: 22.5, Z: 10}, TransformOptions: TransformOptions{Resampling: ResampleModal}})
+		if err != nil {
+			t.Errorf("Could not rotate object: %v", err)
+		}
+		return r
+	}
+	testOperationWithInputFilename(t, fn, "testdata/rotate_3d_modal.vox", "testdata/rotate_y_input.vox")
+
+	v, err := magica.FromFile("testdata/example_input.vox")
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	quarterX, _ := QuarterTurn(v, AxisX, 1)
+	halfZ, _ := QuarterTurn(v, AxisZ, 2)
+	origin := geometry.PointF{}
+
+	testCases := []struct {
+		name     string
+		opts     Rotate3DOptions
+		expected magica.VoxelObject
+	}{
+		{"no rotation", Rotate3DOptions{}, v},
+		{"axis-angle quarter turn", Rotate3DOptions{Axis: geometry.PointF{X: 2}, Angle: 90}, quarterX},
+		{"euler quarter turn", Rotate3DOptions{Euler: geometry.PointF{X: 90}}, quarterX},
+		{"modal quarter turn", Rotate3DOptions{Euler: geometry.PointF{X: 90}, TransformOptions: TransformOptions{Resampling: ResampleModal}}, quarterX},
+		{"keep size around centre", Rotate3DOptions{Euler: geometry.PointF{Z: 180}, TransformOptions: TransformOptions{KeepSize: true}}, halfZ},
+		{"keep size around origin", Rotate3DOptions{Euler: geometry.PointF{Z: 180}, Pivot: &origin, TransformOptions: TransformOptions{KeepSize: true}}, magica.NewVoxelObject(v.Size, v.PaletteData)},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Rotate3D(context.Background(), v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not rotate object: %v", err)
+			}
+
+			if !reflect.DeepEqual(r.Voxels, tc.expected.Voxels) {
+				t.Errorf("Rotated object did not match")
+			}
+		})
+	}
+
+	invalid := []Rotate3DOptions{
+		{Axis: geometry.PointF{X: math.NaN()}},
+		{TransformOptions: TransformOptions{Resampling: "cubic"}},
+		{TransformOptions: TransformOptions{Resampling: ResampleModal, Samples: -1}},
+	}
+
+	for _, opts := range invalid {
+		if _, err := Rotate3D(context.Background(), v, opts); err == nil {
+			t.Errorf("Expected error for options %v", opts)
+		}
+	}
+}
+
+func TestTransform(t *testing.T) {
+	half := geometry.PointF{X: 0.5, Y: 0.5, Z: 0.5}
+
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Transform(context.Background(), v, ScaleMatrix(half), TransformOptions{Resampling: ResampleModal, Samples: 2})
+		if err != nil {
+			t.Errorf("Could not transform object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/transform_half.vox")
+
+	shear, err := ShearMatrix(AxisX, AxisZ, 0.5)
+	if err != nil {
+		t.Fatalf("Could not create shear: %v", err)
+	}
+
+	m, err := ComposeSteps([]TransformStep{
+		{Translate: &geometry.PointF{X: 1}},
+		{Scale: &geometry.PointF{X: 2, Y: 3, Z: 4}},
+		{Rotate: &geometry.PointF{Z: 90}},
+		{Shear: &Shear{Axis: AxisX, Target: AxisZ, Gradient: 0.5}},
+	})
+	if err != nil {
+		t.Fatalf("Could not compose steps: %v", err)
+	}
+
+	// (1,1,1) -> (2,1,1) -> (4,3,4) -> (-3,4,4) -> (-3,4,2.5)
+	expected := geometry.PointF{X: -3, Y: 4, Z: 2.5}
+	p := m.Apply(geometry.PointF{X: 1, Y: 1, Z: 1})
+	if math.Abs(p.X-expected.X)+math.Abs(p.Y-expected.Y)+math.Abs(p.Z-expected.Z) > 1e-9 {
+		t.Errorf("Expected %v, got %v", expected, p)
+	}
+
+	inverse, err := m.Then(shear).Inverse()
+	if err != nil {
+		t.Fatalf("Could not invert transform: %v", err)
+	}
+
+	p = m.Then(shear).Then(inverse).Apply(geometry.PointF{X: 1, Y: 2, Z: 3})
+	if math.Abs(p.X-1)+math.Abs(p.Y-2)+math.Abs(p.Z-3) > 1e-9 {
+		t.Errorf("Expected inverse to undo transform, got %v", p)
+	}
+
+	invalid := [][]TransformStep{
+		{{}},
+		{{Translate: &geometry.PointF{X: 1}, Scale: &geometry.PointF{X: 1}}},
+		{{Shear: &Shear{Axis: AxisX, Target: AxisX, Gradient: 1}}},
+		{{Shear: &Shear{Axis: AxisX, Target: "w", Gradient: 1}}},
+	}
+
+	for _, steps := range invalid {
+		if _, err := ComposeSteps(steps); err == nil {
+			t.Errorf("Expected error for steps %v", steps)
+		}
+	}
+
+	v := magica.NewVoxelObject(geometry.Point{X: 2, Y: 2, Z: 2}, nil)
+	if _, err := Transform(context.Background(), v, ScaleMatrix(geometry.PointF{X: 1, Y: 0, Z: 1}), TransformOptions{}); err == nil {
+		t.Errorf("Expected error for transform which cannot be inverted")
+	}
+
+	if _, err := Transform(context.Background(), v, ScaleMatrix(geometry.PointF{X: 10000, Y: 1, Z: 1}), TransformOptions{}); err == nil {
+		t.Errorf("Expected error for transform which is too large")
+	}
+}
+
+func TestShearObject(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := ShearObject(context.Background(), v, ShearOptions{Shear: Shear{Axis: AxisX, Target: AxisY, Gradient: 0.3}, Smooth: true, Grow: true})
+		if err != nil {
+			t.Errorf("Could not shear object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/shear_xy.vox")
+
+	// A row of voxels along X at the bottom of the object
+	v := magica.NewVoxelObject(geometry.Point{X: 4, Y: 1, Z: 2}, nil)
+	for x := 0; x < 4; x++ {
+		v.Voxels[x][0][0] = byte(x + 1)
+	}
+
+	testCases := []struct {
+		name     string
+		opts     ShearOptions
+		size     geometry.Point
+		expected []int
+	}{
+		{"keep size", ShearOptions{Shear: Shear{Axis: AxisX, Target: AxisZ, Gradient: 1}}, geometry.Point{X: 4, Y: 1, Z: 2}, []int{0, 1, -1, -1}},
+		{"grow", ShearOptions{Shear: Shear{Axis: AxisX, Target: AxisZ, Gradient: 1}, Grow: true}, geometry.Point{X: 4, Y: 1, Z: 6}, []int{0, 1, 2, 3}},
+		{"negative gradient", ShearOptions{Shear: Shear{Axis: AxisX, Target: AxisZ, Gradient: -1}, Grow: true}, geometry.Point{X: 4, Y: 1, Z: 6}, []int{3, 2, 1, 0}},
+		{"fractional gradient", ShearOptions{Shear: Shear{Axis: AxisX, Target: AxisZ, Gradient: 0.5}, Grow: true}, geometry.Point{X: 4, Y: 1, Z: 4}, []int{0, 1, 1, 2}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := ShearObject(context.Background(), v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not shear object: %v", err)
+			}
+
+			if r.Size != tc.size {
+				t.Fatalf("Expected size %v, got %v", tc.size, r.Size)
+			}
+
+			// Each voxel is expected at the given height, or clipped if -1
+			for x, z := range tc.expected {
+				for rz := 0; rz < r.Size.Z; rz++ {
+					expected := byte(0)
+					if rz == z {
+						expected = byte(x + 1)
+					}
+					if r.Voxels[x][0][rz] != expected {
+						t.Errorf("Expected %d at x=%d z=%d, got %d", expected, x, rz, r.Voxels[x][0][rz])
+					}
+				}
+			}
+		})
+	}
+
+	if _, err := ShearObject(context.Background(), v, ShearOptions{Shear: Shear{Axis: AxisY, Target: AxisY, Gradient: 1}}); err == nil {
+		t.Errorf("Expected error for shearing an axis along itself")
+	}
+}
+
+func TestBend(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Bend(context.Background(), v, BendOptions{Radius: 30, Towards: AxisZ, Smooth: true})
+		if err != nil {
+			t.Errorf("Could not bend object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/bend_z.vox")
+
+	bar := magica.NewVoxelObject(geometry.Point{X: 40, Y: 4, Z: 2}, nil)
+	bar.Iterate(func(x, y, z int) { bar.Voxels[x][y][z] = 255 })
+
+	// A very large radius is almost straight
+	r, err := Bend(context.Background(), bar, BendOptions{Radius: 1e7, Towards: AxisY})
+	if err != nil {
+		t.Fatalf("Could not bend object: %v", err)
+	}
+
+	if !reflect.DeepEqual(r.Voxels, bar.Voxels) {
+		t.Errorf("Expected large radius to leave object unchanged")
+	}
+
+	for _, radius := range []float64{12, -12} {
+		r, err := Bend(context.Background(), bar, BendOptions{Radius: radius, Towards: AxisY})
+		if err != nil {
+			t.Fatalf("Could not bend object: %v", err)
+		}
+
+		if r.Size.Z != bar.Size.Z || r.Size.Y <= bar.Size.Y {
+			t.Errorf("Radius %g: unexpected size %v", radius, r.Size)
+		}
+
+		// The bent bar must be a single piece with no gaps
+		if regions := MaskRegions(&r, 255); len(regions) != 1 {
+			t.Errorf("Radius %g: expected 1 piece, got %d", radius, len(regions))
+		}
+
+		// The ends curve towards the positive Y axis for positive radii
+		end, middle := -1, -1
+		for y := 0; y < r.Size.Y; y++ {
+			if r.Voxels[0][y][0] != 0 && end == -1 {
+				end = y
+			}
+			if r.Voxels[r.Size.X/2][y][0] != 0 && middle == -1 {
+				middle = y
+			}
+		}
+
+		if (end > middle) != (radius > 0) {
+			t.Errorf("Radius %g: end at y=%d, middle at y=%d", radius, end, middle)
+		}
+	}
+
+	// Radii of half the thickness or less fold the object over itself, and
+	// radii under length/2π wrap it round more than a full circle
+	invalid := []BendOptions{
+		{Radius: 0, Towards: AxisY},
+		{Radius: 10, Towards: AxisX},
+		{Radius: math.Inf(1), Towards: AxisZ},
+		{Radius: 2, Towards: AxisY},
+		{Radius: -1, Towards: AxisZ},
+		{Radius: 6, Towards: AxisY},
+		{Radius: -6, Towards: AxisZ},
+	}
+
+	for _, opts := range invalid {
+		if _, err := Bend(context.Background(), bar, opts); err == nil {
+			t.Errorf("Expected error for options %v", opts)
+		}
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	v := magica.NewVoxelObject(geometry.Point{X: 4, Y: 1, Z: 2}, []byte{1, 2, 3, 4})
+	for x := 0; x < 4; x++ {
+		v.Voxels[x][0][1] = byte(x + 1)
+	}
+
+	testCases := []struct {
+		name     string
+		opts     TranslateOptions
+		size     geometry.Point
+		expected []byte
+	}{
+		{"positive", TranslateOptions{Offset: geometry.Point{X: 1}}, geometry.Point{X: 4, Y: 1, Z: 2}, []byte{0, 1, 2, 3}},
+		{"negative", TranslateOptions{Offset: geometry.Point{X: -1}}, geometry.Point{X: 4, Y: 1, Z: 2}, []byte{2, 3, 4, 0}},
+		{"wrap", TranslateOptions{Offset: geometry.Point{X: 1}, Wrap: true}, geometry.Point{X: 4, Y: 1, Z: 2}, []byte{4, 1, 2, 3}},
+		{"wrap negative", TranslateOptions{Offset: geometry.Point{X: -5}, Wrap: true}, geometry.Point{X: 4, Y: 1, Z: 2}, []byte{2, 3, 4, 1}},
+		{"grow", TranslateOptions{Offset: geometry.Point{X: 2}, Grow: true}, geometry.Point{X: 6, Y: 1, Z: 2}, []byte{0, 0, 1, 2, 3, 4}},
+		{"grow negative", TranslateOptions{Offset: geometry.Point{X: -2}, Grow: true}, geometry.Point{X: 6, Y: 1, Z: 2}, []byte{1, 2, 3, 4, 0, 0}},
+		{"other axis", TranslateOptions{Offset: geometry.Point{Z: -1}}, geometry.Point{X: 4, Y: 1, Z: 2}, nil},
+		{"other axis wrap", TranslateOptions{Offset: geometry.Point{Z: 1}, Wrap: true}, geometry.Point{X: 4, Y: 1, Z: 2}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Translate(v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not translate object: %v", err)
+			}
+
+			if r.Size != tc.size {
+				t.Fatalf("Expected size %v, got %v", tc.size, r.Size)
+			}
+
+			if !bytes.Equal(r.PaletteData, v.PaletteData) {
+				t.Errorf("Expected palette to be kept")
+			}
+
+			// Moving along Z puts the row at the bottom of the object
+			z := 1
+			if tc.expected == nil {
+				z, tc.expected = 0, []byte{1, 2, 3, 4}
+			}
+
+			for x, expected := range tc.expected {
+				if r.Voxels[x][0][z] != expected {
+					t.Errorf("Expected %d at x=%d, got %d", expected, x, r.Voxels[x][0][z])
+				}
+			}
+		})
+	}
+
+	if _, err := Translate(v, TranslateOptions{Grow: true, Wrap: true}); err == nil {
+		t.Errorf("Expected error for growing and wrapping")
+	}
+
+	for _, offset := range []geometry.Point{{X: 100000}, {Z: -100000}} {
+		if _, err := Translate(v, TranslateOptions{Offset: offset, Grow: true}); err == nil {
+			t.Errorf("Expected error growing by offset %v", offset)
+		}
+	}
+}
+
+func TestCrop(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Trim(v)
+		if err != nil {
+			t.Errorf("Could not trim object: %v", err)
+		}
+		return r
+	}
+	testOperationWithInputFilename(t, fn, "testdata/trim.vox", "testdata/example_centred.vox")
+
+	v := magica.NewVoxelObject(geometry.Point{X: 6, Y: 4, Z: 5}, []byte{1, 2, 3, 4})
+	v.Voxels[1][2][1], v.Voxels[3][2][3] = 10, 255
+
+	r, err := Crop(v, BoundingVolume{Min: geometry.Point{X: 1, Y: 1, Z: 1}, Max: geometry.Point{X: 4, Y: 3, Z: 2}})
+	if err != nil {
+		t.Fatalf("Could not crop object: %v", err)
+	}
+
+	if expected := (geometry.Point{X: 3, Y: 2, Z: 1}); r.Size != expected {
+		t.Errorf("Expected size %v, got %v", expected, r.Size)
+	}
+
+	if r.Voxels[0][1][0] != 10 || !bytes.Equal(r.PaletteData, v.PaletteData) {
+		t.Errorf("Expected voxels and palette to be kept")
+	}
+
+	r, err = Trim(v)
+	if err != nil {
+		t.Fatalf("Could not trim object: %v", err)
+	}
+
+	if expected := (geometry.Point{X: 3, Y: 1, Z: 3}); r.Size != expected {
+		t.Errorf("Expected size %v, got %v", expected, r.Size)
+	}
+
+	if r.Voxels[0][0][0] != 10 || r.Voxels[2][0][2] != 255 {
+		t.Errorf("Expected trimmed object to keep filled and mask voxels")
+	}
+
+	invalid := []BoundingVolume{
+		{Min: geometry.Point{X: 2, Y: 2, Z: 2}, Max: geometry.Point{X: 2, Y: 3, Z: 3}},
+		{Min: geometry.Point{X: -1}, Max: geometry.Point{X: 2, Y: 2, Z: 2}},
+		{Max: geometry.Point{X: 7, Y: 2, Z: 2}},
+	}
+
+	for _, bv := range invalid {
+		if _, err := Crop(v, bv); err == nil {
+			t.Errorf("Expected error for crop volume %v", bv)
+		}
+	}
+
+	if _, err := Trim(magica.NewVoxelObject(v.Size, nil)); err == nil {
+		t.Errorf("Expected error trimming an empty object")
+	}
+}
+
+func TestPad(t *testing.T) {
+	v := magica.NewVoxelObject(geometry.Point{X: 2, Y: 2, Z: 2}, []byte{1, 2, 3, 4})
+	v.Voxels[0][0][0] = 10
+
+	testCases := []struct {
+		name     string
+		opts     PadOptions
+		size     geometry.Point
+		expected geometry.Point
+	}{
+		{"centre", PadOptions{Size: geometry.Point{X: 6, Y: 5, Z: 2}}, geometry.Point{X: 6, Y: 5, Z: 2}, geometry.Point{X: 2, Y: 1}},
+		{"min", PadOptions{Size: geometry.Point{X: 6}, Anchor: Anchors{X: AnchorMin}}, geometry.Point{X: 6, Y: 2, Z: 2}, geometry.Point{}},
+		{"max", PadOptions{Size: geometry.Point{X: 6, Z: 4}, Anchor: Anchors{X: AnchorMax, Z: AnchorMin}}, geometry.Point{X: 6, Y: 2, Z: 4}, geometry.Point{X: 4}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Pad(v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not pad object: %v", err)
+			}
+
+			if r.Size != tc.size {
+				t.Fatalf("Expected size %v, got %v", tc.size, r.Size)
+			}
+
+			if r.Voxels[tc.expected.X][tc.expected.Y][tc.expected.Z] != 10 {
+				t.Errorf("Expected voxel at %v", tc.expected)
+			}
+
+			if !bytes.Equal(r.PaletteData, v.PaletteData) {
+				t.Errorf("Expected palette to be kept")
+			}
+		})
+	}
+
+	if _, err := Pad(v, PadOptions{Size: geometry.Point{X: 1}}); err == nil {
+		t.Errorf("Expected error padding to a smaller size")
+	}
+
+	if _, err := Pad(v, PadOptions{Size: geometry.Point{X: 1000000}}); err == nil {
+		t.Errorf("Expected error padding to a very large size")
+	}
+
+	if _, err := Pad(v, PadOptions{Anchor: Anchors{Y: "top"}}); err == nil {
+		t.Errorf("Expected error for unknown anchor")
+	}
+}
+
+func TestResize(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Resize(context.Background(), v, ResizeOptions{Scale: geometry.PointF{X: 0.5, Y: 0.5, Z: 0.5}})
+		if err != nil {
+			t.Errorf("Could not resize object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/resize_half.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Resize(context.Background(), v, ResizeOptions{Scale: geometry.PointF{X: 2, Y: 2, Z: 2}, Smooth: true})
+		if err != nil {
+			t.Errorf("Could not resize object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/resize_double_smooth.vox")
+
+	v, err := magica.FromFile("testdata/example_input.vox")
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	// Enlarging then shrinking by the same factor is lossless
+	r, err := Resize(context.Background(), v, ResizeOptions{Scale: geometry.PointF{X: 2, Y: 3, Z: 2}})
+	if err == nil {
+		r, err = Resize(context.Background(), r, ResizeOptions{Scale: geometry.PointF{X: 0.5, Y: 1.0 / 3, Z: 0.5}})
+	}
+	if err != nil {
+		t.Fatalf("Could not resize object: %v", err)
+	}
+
+	if !reflect.DeepEqual(r.Voxels, v.Voxels) {
+		t.Errorf("Expected enlarging and shrinking to be lossless")
+	}
+
+	object := func(size geometry.Point, colours ...byte) magica.VoxelObject {
+		o := magica.NewVoxelObject(size, nil)
+		i := 0
+		o.Iterate(func(x, y, z int) {
+			if i < len(colours) {
+				o.Voxels[x][y][z] = colours[i]
+			}
+			i++
+		})
+		return o
+	}
+
+	cube := geometry.Point{X: 2, Y: 2, Z: 2}
+	half := geometry.PointF{X: 0.5, Y: 0.5, Z: 0.5}
+
+	testCases := []struct {
+		name     string
+		input    magica.VoxelObject
+		opts     ResizeOptions
+		expected byte
+	}{
+		{"tie goes to lowest index", object(geometry.Point{X: 2, Y: 1, Z: 1}, 5, 3), ResizeOptions{Scale: geometry.PointF{X: 0.5}}, 3},
+		{"modal", object(cube, 5, 3, 5, 5, 3), ResizeOptions{Scale: half}, 5},
+		{"mostly empty", object(cube, 5, 5, 5), ResizeOptions{Scale: half}, 0},
+		{"half empty", object(cube, 5, 5, 5, 5), ResizeOptions{Scale: half}, 5},
+		{"empty beats tie", object(cube, 5, 5, 3, 3), ResizeOptions{Scale: half}, 0},
+		{"tie beats empty", object(cube, 5, 5, 5, 3, 3, 3), ResizeOptions{Scale: half}, 3},
+		{"mask survives", object(cube, 5, 5, 5, 5, 5, 5, 5, 255), ResizeOptions{Scale: half}, 255},
+		{"other mask index", object(cube, 5, 5, 5, 5, 5, 5, 5, 20), ResizeOptions{Scale: half, MaskIndex: 20}, 20},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Resize(context.Background(), tc.input, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not resize object: %v", err)
+			}
+
+			if r.Size != (geometry.Point{X: 1, Y: 1, Z: 1}) {
+				t.Fatalf("Expected size 1x1x1, got %v", r.Size)
+			}
+
+			if r.Voxels[0][0][0] != tc.expected {
+				t.Errorf("Expected %d, got %d", tc.expected, r.Voxels[0][0][0])
+			}
+		})
+	}
+
+	// An L shape in X and Z, with the corner at (1,1) empty
+	l := object(geometry.Point{X: 2, Y: 1, Z: 2}, 5, 5, 5)
+	double := geometry.PointF{X: 2, Z: 2}
+
+	r, err = Resize(context.Background(), l, ResizeOptions{Scale: double})
+	if err != nil {
+		t.Fatalf("Could not resize object: %v", err)
+	}
+
+	if r.Voxels[0][0][0] != 5 || r.Voxels[2][0][2] != 0 {
+		t.Errorf("Expected nearest enlarging to repeat voxels")
+	}
+
+	r, err = Resize(context.Background(), l, ResizeOptions{Scale: double, Smooth: true})
+	if err != nil {
+		t.Fatalf("Could not resize object: %v", err)
+	}
+
+	if r.Voxels[0][0][0] != 0 || r.Voxels[2][0][2] != 5 || r.Voxels[1][0][1] != 5 {
+		t.Errorf("Expected smoothing to cut the outer corner and fill the inner one")
+	}
+
+	r, err = Resize(context.Background(), object(geometry.Point{X: 1, Y: 1, Z: 1}, 5), ResizeOptions{Scale: geometry.PointF{X: 3, Y: 3, Z: 3}, Smooth: true})
+	if err != nil {
+		t.Fatalf("Could not resize object: %v", err)
+	}
+
+	count := 0
+	r.Iterate(func(x, y, z int) {
+		if r.Voxels[x][y][z] == 5 {
+			count++
+		}
+	})
+
+	if count != 27 {
+		t.Errorf("Expected single voxel to be kept by smoothing, got %d of 27 voxels", count)
+	}
+
+	// Smoothing a diagonal stair of mask voxels must not turn body voxels
+	// into mask
+	stair := object(geometry.Point{X: 2, Y: 1, Z: 2}, 5, 255, 255, 5)
+	r, err = Resize(context.Background(), stair, ResizeOptions{Scale: double, Smooth: true})
+	if err != nil {
+		t.Fatalf("Could not resize object: %v", err)
+	}
+
+	masked := 0
+	r.Iterate(func(x, y, z int) {
+		if r.Voxels[x][y][z] == 255 {
+			masked++
+		}
+	})
+
+	if masked != 8 {
+		t.Errorf("Expected smoothing to keep 8 mask voxels, got %d", masked)
+	}
+
+	// Mask voxels are voted on like other colours, but a region which loses
+	// everywhere still keeps one voxel
+	maskCases := []struct {
+		name     string
+		input    []byte
+		expected []byte
+	}{
+		{"mask loses tie", []byte{255, 255, 255, 5, 5, 5, 5, 5}, []byte{255, 5, 5, 5}},
+		{"stray mask kept", []byte{5, 5, 5, 5, 5, 5, 5, 255}, []byte{5, 5, 5, 255}},
+		{"split region kept once", []byte{5, 5, 5, 255, 255, 5, 5, 5}, []byte{5, 255, 5, 5}},
+	}
+
+	for _, tc := range maskCases {
+		r, err := Resize(context.Background(), object(geometry.Point{X: 8, Y: 1, Z: 1}, tc.input...), ResizeOptions{Scale: geometry.PointF{X: 0.5}})
+		if err != nil {
+			t.Fatalf("Could not resize object: %v", err)
+		}
+
+		got := make([]byte, r.Size.X)
+		for x := range got {
+			got[x] = r.Voxels[x][0][0]
+		}
+
+		if !bytes.Equal(got, tc.expected) {
+			t.Errorf("%s: expected %v, got %v", tc.name, tc.expected, got)
+		}
+	}
+
+	for _, scale := range []geometry.PointF{{X: -1}, {Y: math.NaN()}, {Z: 10000}} {
+		if _, err := Resize(context.Background(), v, ResizeOptions{Scale: scale}); err == nil {
+			t.Errorf("Expected error for scale %v", scale)
+		}
+	}
+}
+
+func testOperation(t *testing.T, op func(v magica.VoxelObject) magica.VoxelObject, filename string) {
+	testOperationWithInputFilename(t, op, filename, "testdata/example_input.vox")
+}
+
+func testOperationWithInputFilename(t *testing.T, op func(v magica.VoxelObject) magica.VoxelObject, filename, inputFilename string) {
+	testOperationWithInputFilenameAndLayers(t, op, filename, inputFilename, []int{})
+}
+
+func testOperationWithInputFilenameAndLayers(t *testing.T, op func(v magica.VoxelObject) magica.VoxelObject, filename, inputFilename string, layers []int) {
+	input, err := magica.FromFileWithLayers(inputFilename, layers)
+	if err != nil {
+		t.Errorf("Could not read object: %v", err)
+	}
+
+	output := op(input)
+
+	buf := bytes.Buffer{}
+	if err := output.Save(&buf); err != nil {
+		t.Errorf("Could not save object: %v", err)
+	}
+
+	// Create test output if it doesn't exist. Check created output
+	// in MagicaVoxel to ensure it is correct.
+	if _, err := os.Stat(filename); err != nil {
+		of, _ := os.Create(filename)
+		output.Save(of)
+	}
+
+	result, err := utils.CompareToFile(buf.Bytes(), filename)
+	if err != nil {
+		t.Errorf("Could not read expected output file: %v", err)
+	}
+
+	if !result {
+		t.Errorf("Output %s did not equal expected", filename)
+	}
+}
+
+func TestRemove(t *testing.T) {
+	src, err := magica.FromFile("testdata/example_small.vox")
+	if err != nil {
+		t.Errorf("Could not read object: %v", err)
+	}
+
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		return Remove(v, src, 0)
+	}
+	testOperation(t, fn, "testdata/remove.vox")
+
+	fn = func(v magica.VoxelObject) magica.VoxelObject {
+		return Remove(v, src, 255)
+	}
+	testOperation(t, fn, "testdata/clip.vox")
+}
+
+func TestRecolourObject(t *testing.T) {
+	v := magica.NewVoxelObject(geometry.Point{X: 4, Y: 1, Z: 1}, nil)
+	v.Voxels[0][0][0], v.Voxels[1][0][0], v.Voxels[2][0][0], v.Voxels[3][0][0] = 3, 10, 255, 20
+
+	testCases := []struct {
+		name     string
+		opts     RecolourOptions
+		expected []byte
+	}{
+		{"single ramp", RecolourOptions{InputRamps: []string{"3-12"}, OutputRamps: []string{"72-81"}}, []byte{72, 79, 255, 20}},
+		{"ramps are not applied twice", RecolourOptions{InputRamps: []string{"3-12", "72-81"}, OutputRamps: []string{"72-81", "1-10"}}, []byte{72, 79, 255, 20}},
+		{"mask preserved", RecolourOptions{InputRamps: []string{"250-255"}, OutputRamps: []string{"100-105"}}, []byte{3, 10, 255, 20}},
+		{"mask recoloured", RecolourOptions{InputRamps: []string{"250-255"}, OutputRamps: []string{"100-105"}, IncludeMask: true}, []byte{3, 10, 105, 20}},
+		{"other mask index", RecolourOptions{InputRamps: []string{"15-20"}, OutputRamps: []string{"30-35"}, MaskIndex: 20}, []byte{3, 10, 255, 20}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RecolourObject(v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not recolour object: %v", err)
+			}
+
+			for x, expected := range tc.expected {
+				if r.Voxels[x][0][0] != expected {
+					t.Errorf("Expected %d at x=%d, got %d", expected, x, r.Voxels[x][0][0])
+				}
+			}
+		})
+	}
+}
+
+func greyPalette(greys map[byte]byte, fill byte) []byte {
+	palette := make([]byte, 1024)
+	for c := 1; c < 256; c++ {
+		g, ok := greys[byte(c)]
+		if !ok {
+			g = fill
+		}
+		i := (c - 1) * 4
+		palette[i], palette[i+1], palette[i+2], palette[i+3] = g, g, g, 255
+	}
+	return palette
+}
+
+func TestRecolourByPalette(t *testing.T) {
+	palette := greyPalette(map[byte]byte{1: 0, 2: 30, 3: 255, 10: 0, 11: 30, 12: 60, 13: 120, 14: 255}, 0)
+	target := greyPalette(map[byte]byte{50: 30}, 200)
+
+	v := magica.NewVoxelObject(geometry.Point{X: 4, Y: 1, Z: 1}, palette)
+	v.Voxels[0][0][0], v.Voxels[1][0][0], v.Voxels[2][0][0], v.Voxels[3][0][0] = 1, 2, 3, 255
+
+	testCases := []struct {
+		name     string
+		opts     RecolourOptions
+		expected []byte
+	}{
+		{"index", RecolourOptions{InputRamps: []string{"1-3"}, OutputRamps: []string{"10-14"}}, []byte{10, 12, 14, 255}},
+		{"luminance", RecolourOptions{InputRamps: []string{"1-3"}, OutputRamps: []string{"10-14"}, Mode: RecolourLuminance}, []byte{10, 11, 14, 255}},
+		{"luminance reversed", RecolourOptions{InputRamps: []string{"1-3"}, OutputRamps: []string{"14-10"}, Mode: RecolourLuminance}, []byte{10, 11, 14, 255}},
+		{"palette", RecolourOptions{Mode: RecolourPalette, Palette: target}, []byte{50, 50, 1, 255}},
+		{"palette with ramp", RecolourOptions{InputRamps: []string{"2-2"}, OutputRamps: []string{"60-61"}, Mode: RecolourPalette, Palette: target}, []byte{50, 60, 1, 255}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := RecolourObject(v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not recolour object: %v", err)
+			}
+
+			for x, expected := range tc.expected {
+				if r.Voxels[x][0][0] != expected {
+					t.Errorf("Expected %d at x=%d, got %d", expected, x, r.Voxels[x][0][0])
+				}
+			}
+
+			if tc.opts.Mode == RecolourPalette && !bytes.Equal(r.PaletteData, target) {
+				t.Errorf("Expected target palette to be used")
+			}
+		})
+	}
+
+	if _, err := RecolourObject(magica.NewVoxelObject(v.Size, nil), RecolourOptions{Mode: RecolourLuminance}); err == nil {
+		t.Errorf("Expected error for object with no palette")
+	}
+
+	if _, err := RecolourObject(v, RecolourOptions{Mode: RecolourPalette}); err == nil {
+		t.Errorf("Expected error for missing target palette")
+	}
+}
+
+func TestMirror(t *testing.T) {
+	fn := func(v magica.VoxelObject) magica.VoxelObject {
+		r, err := Mirror(v, AxisX, AxisY)
+		if err != nil {
+			t.Errorf("Could not mirror object: %v", err)
+		}
+		return r
+	}
+	testOperation(t, fn, "testdata/mirror_xy.vox")
+
+	v := magica.NewVoxelObject(geometry.Point{X: 3, Y: 2, Z: 4}, []byte{1, 2, 3, 4})
+	v.Voxels[0][1][3], v.Voxels[1][0][0] = 10, 255
+
+	r, err := Mirror(v, AxisZ)
+	if err != nil {
+		t.Fatalf("Could not mirror object: %v", err)
+	}
+
+	if r.Voxels[0][1][0] != 10 || r.Voxels[1][0][3] != 255 || r.Voxels[0][1][3] != 0 {
+		t.Errorf("Unexpected mirrored voxels")
+	}
+
+	if r.Size != v.Size || !bytes.Equal(r.PaletteData, v.PaletteData) {
+		t.Errorf("Expected size and palette to be unchanged")
+	}
+
+	if _, err := Mirror(v, Axis("w")); err == nil {
+		t.Errorf("Expected error for unknown axis")
+	}
+}
+
+func TestSymmetrize(t *testing.T) {
+	v := magica.NewVoxelObject(geometry.Point{X: 5, Y: 1, Z: 1}, nil)
+	for x, c := range []byte{1, 2, 3, 4, 255} {
+		v.Voxels[x][0][0] = c
+	}
+
+	testCases := []struct {
+		name     string
+		opts     SymmetrizeOptions
+		expected []byte
+	}{
+		{"centre", SymmetrizeOptions{Axis: AxisX}, []byte{1, 2, 3, 2, 1}},
+		{"from max", SymmetrizeOptions{Axis: AxisX, FromMax: true}, []byte{255, 4, 3, 4, 255}},
+		{"between voxels", SymmetrizeOptions{Axis: AxisX, Plane: 2}, []byte{1, 2, 2, 1, 0}},
+		{"other axis", SymmetrizeOptions{Axis: AxisY}, []byte{1, 2, 3, 4, 255}},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			r, err := Symmetrize(v, tc.opts)
+			if err != nil {
+				t.Fatalf("Could not symmetrize object: %v", err)
+			}
+
+			for x, expected := range tc.expected {
+				if r.Voxels[x][0][0] != expected {
+					t.Errorf("Expected %d at x=%d, got %d", expected, x, r.Voxels[x][0][0])
+				}
+			}
+		})
+	}
+
+	for _, plane := range []float64{-1, 6, 1.25} {
+		if _, err := Symmetrize(v, SymmetrizeOptions{Axis: AxisX, Plane: plane}); err == nil {
+			t.Errorf("Expected error for plane %g", plane)
+		}
+	}
+}
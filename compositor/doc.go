@@ -0,0 +1,11 @@
+// Package compositor composites MagicaVoxel objects, for example to add
+// cargo to the mask area of a vehicle, recolour objects or rotate them.
+//
+// Each operation takes a magica.VoxelObject and returns a new object,
+// leaving its input unchanged. Operations that can fail return an error
+// rather than logging, so they can be used directly from other tools.
+//
+// A Batch loads a set of input files, applies a list of operations to each
+// of them and saves the results. Batches are usually loaded from JSON with
+// FromFile or FromJson.
+package compositor
@@ -0,0 +1,105 @@
+package compositor
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/mattkimber/gandalf/magica"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// ExecOptions controls how Exec runs an external command
+type ExecOptions struct {
+	// Command is the program to run followed by its arguments
+	Command []string
+
+	// Timeout is the maximum time the command may run for. 0 means no limit.
+	Timeout time.Duration
+}
+
+// Exec runs an external command to transform an object.
+//
+// The command receives the input object followed by any source objects on
+// stdin. Each object is sent as a 4-byte little-endian length followed by
+// that many bytes of MagicaVoxel .vox data. The command must write a single
+// .vox object to stdout, which is returned as the result.
+//
+// A non-zero exit code, a timeout or output which is not a valid .vox
+// object are returned as errors, including anything the command wrote to
+// stderr. The command is killed if ctx is cancelled.
+func Exec(ctx context.Context, v magica.VoxelObject, sources []magica.VoxelObject, opts ExecOptions) (r magica.VoxelObject, err error) {
+	if len(opts.Command) == 0 || opts.Command[0] == "" {
+		return r, fmt.Errorf("no command specified")
+	}
+
+	stdin := bytes.Buffer{}
+	for _, obj := range append([]magica.VoxelObject{v}, sources...) {
+		if err := writeObject(&stdin, obj); err != nil {
+			return r, err
+		}
+	}
+
+	if opts.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, opts.Timeout)
+		defer cancel()
+	}
+
+	stdout, stderr := bytes.Buffer{}, bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, opts.Command[0], opts.Command[1:]...)
+	cmd.Stdin = &stdin
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	// Don't wait indefinitely for child processes of the command which
+	// still hold stdout or stderr open after it has been killed
+	cmd.WaitDelay = time.Second
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.Canceled) {
+			return r, fmt.Errorf("command %s was cancelled: %w", opts.Command[0], ctx.Err())
+		}
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			return r, fmt.Errorf("command %s timed out after %v%s", opts.Command[0], opts.Timeout, formatStderr(stderr))
+		}
+		return r, fmt.Errorf("command %s failed: %w%s", opts.Command[0], err, formatStderr(stderr))
+	}
+
+	if stdout.Len() == 0 {
+		return r, fmt.Errorf("command %s produced no output%s", opts.Command[0], formatStderr(stderr))
+	}
+
+	r, err = magica.GetFromReader(&stdout, []int{})
+	if err != nil {
+		return r, fmt.Errorf("could not read output of command %s: %w%s", opts.Command[0], err, formatStderr(stderr))
+	}
+
+	return r, nil
+}
+
+func writeObject(buf *bytes.Buffer, v magica.VoxelObject) error {
+	data := bytes.Buffer{}
+	if err := v.Save(&data); err != nil {
+		return fmt.Errorf("could not encode object: %w", err)
+	}
+
+	if err := binary.Write(buf, binary.LittleEndian, uint32(data.Len())); err != nil {
+		return err
+	}
+
+	_, err := buf.Write(data.Bytes())
+	return err
+}
+
+func formatStderr(stderr bytes.Buffer) string {
+	msg := strings.TrimSpace(stderr.String())
+	if msg == "" {
+		return ""
+	}
+
+	return ": " + msg
+}
@@ -0,0 +1,70 @@
+package compositor
+
+import (
+	"bytes"
+	"context"
+	"github.com/mattkimber/gandalf/magica"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func requireCommand(t *testing.T, name string) {
+	if _, err := exec.LookPath(name); err != nil {
+		t.Skipf("%s not available: %v", name, err)
+	}
+}
+
+func TestExec(t *testing.T) {
+	requireCommand(t, "tail")
+
+	input, err := magica.FromFile("testdata/example_input.vox")
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	// Skip the length prefix and echo the object back
+	output, err := Exec(context.Background(), input, nil, ExecOptions{Command: []string{"tail", "-c", "+5"}})
+	if err != nil {
+		t.Fatalf("Could not execute command: %v", err)
+	}
+
+	expected, actual := bytes.Buffer{}, bytes.Buffer{}
+	input.Save(&expected)
+	output.Save(&actual)
+
+	if !bytes.Equal(expected.Bytes(), actual.Bytes()) {
+		t.Errorf("Output of command did not equal input")
+	}
+}
+
+func TestExecErrors(t *testing.T) {
+	requireCommand(t, "sh")
+
+	input, err := magica.FromFile("testdata/example_input.vox")
+	if err != nil {
+		t.Fatalf("Could not read object: %v", err)
+	}
+
+	testCases := []struct {
+		name     string
+		opts     ExecOptions
+		expected string
+	}{
+		{"no command", ExecOptions{}, "no command"},
+		{"exit code", ExecOptions{Command: []string{"sh", "-c", "cat > /dev/null; echo broken >&2; exit 3"}}, "broken"},
+		{"no output", ExecOptions{Command: []string{"sh", "-c", "cat > /dev/null"}}, "no output"},
+		{"invalid output", ExecOptions{Command: []string{"sh", "-c", "cat > /dev/null; echo nonsense"}}, "could not read output"},
+		{"timeout", ExecOptions{Command: []string{"sh", "-c", "sleep 5"}, Timeout: 50 * time.Millisecond}, "timed out"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			_, err := Exec(context.Background(), input, nil, tc.opts)
+			if err == nil || !strings.Contains(err.Error(), tc.expected) {
+				t.Errorf("Expected error containing %q, got %v", tc.expected, err)
+			}
+		})
+	}
+}
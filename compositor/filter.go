@@ -0,0 +1,88 @@
+package compositor
+
+import (
+	"fmt"
+)
+
+// Filter selects how a colour is chosen when several source voxels map to a
+// single output voxel
+type Filter string
+
+const (
+	// FilterModal uses the most common colour, with ties going to the lowest index
+	FilterModal Filter = "modal"
+	// FilterNearest uses the source voxel nearest the centre of the output voxel
+	FilterNearest Filter = "nearest"
+	// FilterDarkest uses the colour with the lowest luminance in the
+	// palette, or the lowest index if there is no palette
+	FilterDarkest Filter = "darkest"
+	// FilterLightest uses the colour with the highest luminance in the
+	// palette, or the highest index if there is no palette
+	FilterLightest Filter = "lightest"
+	// FilterPriority uses the first colour from a priority list which is
+	// present, falling back to the modal colour
+	FilterPriority Filter = "priority"
+)
+
+func (f Filter) validate() error {
+	switch f {
+	case "", FilterModal, FilterNearest, FilterDarkest, FilterLightest, FilterPriority:
+		return nil
+	}
+
+	return fmt.Errorf("unknown filter %s", f)
+}
+
+// colourCounts is the number of voxels of each colour in a region.
+// An array rather than a map is used so that iteration order, and
+// therefore tie-breaking, is deterministic.
+type colourCounts [256]int
+
+// pick chooses a colour from the counts according to the filter, ignoring
+// empty voxels. The palette is used to compare brightness for the darkest
+// and lightest filters. It returns 0 if there are no filled voxels.
+func (c *colourCounts) pick(filter Filter, priority []byte, palette []byte) byte {
+	switch filter {
+	case FilterDarkest:
+		darkest := byte(0)
+		for i := 1; i < len(c); i++ {
+			if c[i] > 0 && (darkest == 0 || brightness(palette, byte(i)) < brightness(palette, darkest)) {
+				darkest = byte(i)
+			}
+		}
+		return darkest
+	case FilterLightest:
+		lightest := byte(0)
+		for i := len(c) - 1; i > 0; i-- {
+			if c[i] > 0 && (lightest == 0 || brightness(palette, byte(i)) > brightness(palette, lightest)) {
+				lightest = byte(i)
+			}
+		}
+		return lightest
+	case FilterPriority:
+		for _, p := range priority {
+			if p != 0 && c[p] > 0 {
+				return p
+			}
+		}
+	}
+
+	maxCount, modal := 0, byte(0)
+	for i := 1; i < len(c); i++ {
+		if c[i] > maxCount {
+			maxCount = c[i]
+			modal = byte(i)
+		}
+	}
+
+	return modal
+}
+
+// brightness orders colours from dark to light, using their luminance in the
+// palette, or their index if there is no palette
+func brightness(palette []byte, c byte) float64 {
+	if len(palette) < paletteSize {
+		return float64(c)
+	}
+	return luminance(palette, c)
+}
@@ -0,0 +1,202 @@
+package compositor
+
+import (
+	"errors"
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+)
+
+// DefaultMaskIndex is the colour index used for mask voxels unless another
+// is specified
+const DefaultMaskIndex byte = 255
+
+// maskOrDefault returns the mask index, or DefaultMaskIndex if it is 0
+func maskOrDefault(maskIndex byte) byte {
+	if maskIndex == 0 {
+		return DefaultMaskIndex
+	}
+	return maskIndex
+}
+
+// MaskPolicy selects what an operation does when its input has no mask
+// voxels
+type MaskPolicy string
+
+const (
+	// MaskPolicyError fails the operation with ErrNoMask. This is the default.
+	MaskPolicyError MaskPolicy = "error"
+	// MaskPolicySkip also returns ErrNoMask from the operation, but batches
+	// will warn and skip the output rather than failing
+	MaskPolicySkip MaskPolicy = "skip"
+	// MaskPolicyWhole treats the whole object as the cargo area, as if
+	// IgnoreMask had been set
+	MaskPolicyWhole MaskPolicy = "whole"
+)
+
+func (p MaskPolicy) validate() error {
+	switch p {
+	case "", MaskPolicyError, MaskPolicySkip, MaskPolicyWhole:
+		return nil
+	}
+
+	return fmt.Errorf("unknown mask policy %s", p)
+}
+
+// ErrNoMask is returned by operations which need a cargo area when the
+// object has no mask voxels
+var ErrNoMask = errors.New("no mask voxels found (no cargo area)")
+
+// getBounds returns the inclusive bounds of the mask voxels, or ErrNoMask if
+// there are none
+func getBounds(v *magica.VoxelObject, ignoreMask bool, mask byte) (geometry.Bounds, error) {
+
+	minP := geometry.Point{X: v.Size.X, Y: v.Size.Y, Z: v.Size.Z}
+	maxP := geometry.Point{}
+
+	if ignoreMask {
+		return geometry.Bounds{Min: maxP, Max: geometry.Point{X: v.Size.X - 1, Y: v.Size.Y - 1, Z: v.Size.Z - 1}}, nil
+	}
+
+	iterator := func(x, y, z int) {
+		if v.Voxels[x][y][z] == mask {
+			if x < minP.X {
+				minP.X = x
+			}
+			if y < minP.Y {
+				minP.Y = y
+			}
+			if z < minP.Z {
+				minP.Z = z
+			}
+			if x > maxP.X {
+				maxP.X = x
+			}
+			if y > maxP.Y {
+				maxP.Y = y
+			}
+			if z > maxP.Z {
+				maxP.Z = z
+			}
+		}
+	}
+
+	v.Iterate(iterator)
+
+	if minP.X > maxP.X {
+		return geometry.Bounds{}, ErrNoMask
+	}
+
+	return geometry.Bounds{Min: minP, Max: maxP}, nil
+}
+
+// HasMask returns true if the object contains any voxels of the mask
+// index. A maskIndex of 0 uses DefaultMaskIndex.
+func HasMask(v *magica.VoxelObject, maskIndex byte) bool {
+	_, err := getBounds(v, false, maskOrDefault(maskIndex))
+	return err == nil
+}
+
+// MaskRegions returns the inclusive bounds of each separate region of mask
+// voxels, where a region is a set of mask voxels connected by their faces.
+// Regions are numbered from 1 in the order their first voxel is found when
+// scanning in x, then y, then z, so region n is at index n-1. A maskIndex of
+// 0 uses DefaultMaskIndex.
+func MaskRegions(v *magica.VoxelObject, maskIndex byte) []geometry.Bounds {
+	_, regions := labelMask(v, maskOrDefault(maskIndex))
+	return regions
+}
+
+// labelMask labels each mask voxel with the number of the connected region
+// it belongs to, and returns the labels along with the bounds of each region
+func labelMask(v *magica.VoxelObject, mask byte) (labels [][][]int, regions []geometry.Bounds) {
+	labels = make([][][]int, v.Size.X)
+	for x := range labels {
+		labels[x] = make([][]int, v.Size.Y)
+		for y := range labels[x] {
+			labels[x][y] = make([]int, v.Size.Z)
+		}
+	}
+
+	neighbours := []geometry.Point{{X: -1}, {X: 1}, {Y: -1}, {Y: 1}, {Z: -1}, {Z: 1}}
+	bounds := geometry.Bounds{Max: v.Size}
+
+	v.Iterate(func(x, y, z int) {
+		if v.Voxels[x][y][z] != mask || labels[x][y][z] != 0 {
+			return
+		}
+
+		label := len(regions) + 1
+		region := geometry.Bounds{Min: geometry.Point{X: x, Y: y, Z: z}, Max: geometry.Point{X: x, Y: y, Z: z}}
+		labels[x][y][z] = label
+		queue := []geometry.Point{{X: x, Y: y, Z: z}}
+
+		for len(queue) > 0 {
+			p := queue[0]
+			queue = queue[1:]
+
+			region.Min = geometry.Point{X: min(region.Min.X, p.X), Y: min(region.Min.Y, p.Y), Z: min(region.Min.Z, p.Z)}
+			region.Max = geometry.Point{X: max(region.Max.X, p.X), Y: max(region.Max.Y, p.Y), Z: max(region.Max.Z, p.Z)}
+
+			for _, n := range neighbours {
+				q := geometry.Point{X: p.X + n.X, Y: p.Y + n.Y, Z: p.Z + n.Z}
+				if q.IsInBounds(bounds) && v.Voxels[q.X][q.Y][q.Z] == mask && labels[q.X][q.Y][q.Z] == 0 {
+					labels[q.X][q.Y][q.Z] = label
+					queue = append(queue, q)
+				}
+			}
+		}
+
+		regions = append(regions, region)
+	})
+
+	return labels, regions
+}
+
+// cargoArea is the part of an object which cargo is composited into
+type cargoArea struct {
+	bounds     geometry.Bounds
+	ignoreMask bool
+	mask       byte
+	region     int
+	labels     [][][]int
+}
+
+// isMask returns true if the voxel is a mask voxel in the area's region
+func (a *cargoArea) isMask(v *magica.VoxelObject, x, y, z int) bool {
+	return v.Voxels[x][y][z] == a.mask && (a.region == 0 || a.labels[x][y][z] == a.region)
+}
+
+// getCargoArea returns the cargo area of an object, applying the policy if
+// there are no mask voxels. If region is non-zero only that connected region
+// of the mask is used.
+func getCargoArea(v *magica.VoxelObject, ignoreMask bool, mask byte, policy MaskPolicy, region int) (cargoArea, error) {
+	area := cargoArea{ignoreMask: ignoreMask, mask: mask}
+
+	if err := policy.validate(); err != nil {
+		return area, err
+	}
+
+	if region < 0 {
+		return area, fmt.Errorf("invalid mask region %d", region)
+	}
+
+	var err error
+	area.bounds, err = getBounds(v, ignoreMask, mask)
+	if errors.Is(err, ErrNoMask) && policy == MaskPolicyWhole {
+		area.ignoreMask = true
+		area.bounds, err = getBounds(v, true, mask)
+	}
+
+	if err != nil || area.ignoreMask || region == 0 {
+		return area, err
+	}
+
+	labels, regions := labelMask(v, mask)
+	if region > len(regions) {
+		return area, fmt.Errorf("mask region %d not found (object has %d mask regions)", region, len(regions))
+	}
+
+	area.bounds, area.region, area.labels = regions[region-1], region, labels
+	return area, nil
+}
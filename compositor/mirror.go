@@ -0,0 +1,100 @@
+package compositor
+
+import (
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+)
+
+// Mirror flips an object along each of the given axes. Mask voxels and the
+// palette are unchanged.
+func Mirror(v magica.VoxelObject, axes ...Axis) (r magica.VoxelObject, err error) {
+	flip := geometry.Point{}
+	for _, a := range axes {
+		if err := a.validate(); err != nil {
+			return v.Copy(), err
+		}
+		a.set(&flip, 1)
+	}
+
+	r = v.Copy()
+	r.Iterate(func(x, y, z int) {
+		sx, sy, sz := x, y, z
+		if flip.X == 1 {
+			sx = v.Size.X - 1 - x
+		}
+		if flip.Y == 1 {
+			sy = v.Size.Y - 1 - y
+		}
+		if flip.Z == 1 {
+			sz = v.Size.Z - 1 - z
+		}
+		r.Voxels[x][y][z] = v.Voxels[sx][sy][sz]
+	})
+
+	return r, nil
+}
+
+// SymmetrizeOptions controls how Symmetrize copies one half of an object
+// onto the other
+type SymmetrizeOptions struct {
+	// Axis is the axis perpendicular to the mirror plane
+	Axis Axis
+
+	// Plane is the position of the mirror plane along the axis, measured
+	// from the minimum face of the object. It must be a multiple of 0.5.
+	// 0 uses the centre of the object.
+	Plane float64
+
+	// FromMax copies the half above the plane onto the half below it. By
+	// default the half below the plane is copied.
+	FromMax bool
+}
+
+// Symmetrize replaces one half of an object with a mirror image of the
+// other half. Voxels whose mirror image lies outside the object are left
+// empty. Mask voxels and the palette are unchanged.
+func Symmetrize(v magica.VoxelObject, opts SymmetrizeOptions) (r magica.VoxelObject, err error) {
+	r = v.Copy()
+
+	if err := opts.Axis.validate(); err != nil {
+		return r, err
+	}
+
+	size := opts.Axis.of(v.Size)
+	plane := opts.Plane
+	if plane == 0 {
+		plane = float64(size) / 2
+	}
+
+	if plane < 0 || plane > float64(size) || math.IsNaN(plane) {
+		return r, fmt.Errorf("mirror plane %g is outside the object (0-%d)", plane, size)
+	}
+
+	if plane*2 != math.Trunc(plane*2) {
+		return r, fmt.Errorf("mirror plane %g is not a multiple of 0.5", plane)
+	}
+
+	// Voxel i has its centre at i+0.5, so its mirror image is 2*plane-1-i
+	r.Iterate(func(x, y, z int) {
+		p := geometry.Point{X: x, Y: y, Z: z}
+		i := opts.Axis.of(p)
+		centre := float64(i) + 0.5
+
+		if centre == plane || (centre < plane) != opts.FromMax {
+			return
+		}
+
+		m := int(plane*2) - 1 - i
+		if m < 0 || m >= size {
+			r.Voxels[x][y][z] = 0
+			return
+		}
+
+		opts.Axis.set(&p, m)
+		r.Voxels[x][y][z] = v.Voxels[p.X][p.Y][p.Z]
+	})
+
+	return r, nil
+}
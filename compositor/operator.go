@@ -0,0 +1,94 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"github.com/mattkimber/gandalf/magica"
+	"sort"
+	"sync"
+)
+
+// Parameter describes a single batch file field read by an operator
+type Parameter struct {
+	Name        string
+	Description string
+	Required    bool
+}
+
+// Operator is implemented by every operation type which can be referenced
+// from a batch file
+type Operator interface {
+	// Parameters lists the operation fields the operator uses
+	Parameters() []Parameter
+
+	// Validate checks the operation before any files are loaded
+	Validate(op Operation) error
+
+	// Apply performs the operation on input. Sources holds the objects
+	// loaded from the operation's file, if it has one. Long-running
+	// operators should stop and return an error when ctx is cancelled.
+	Apply(ctx context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error)
+}
+
+var (
+	operatorsMutex sync.RWMutex
+	operators      = map[string]Operator{}
+)
+
+// Register makes an operator available to batch files under the given type
+// name. It returns an error if the name is already registered.
+func Register(name string, operator Operator) error {
+	operatorsMutex.Lock()
+	defer operatorsMutex.Unlock()
+
+	if name == "" {
+		return fmt.Errorf("operator name cannot be empty")
+	}
+
+	if operator == nil {
+		return fmt.Errorf("operator %s cannot be nil", name)
+	}
+
+	if _, ok := operators[name]; ok {
+		return fmt.Errorf("operator %s is already registered", name)
+	}
+
+	operators[name] = operator
+	return nil
+}
+
+// LookupOperator returns the operator registered under the given type name
+func LookupOperator(name string) (Operator, bool) {
+	operatorsMutex.RLock()
+	defer operatorsMutex.RUnlock()
+
+	operator, ok := operators[name]
+	return operator, ok
+}
+
+// OperatorNames returns the type names of all registered operators, in
+// alphabetical order
+func OperatorNames() []string {
+	operatorsMutex.RLock()
+	defer operatorsMutex.RUnlock()
+
+	names := make([]string, 0, len(operators))
+	for name := range operators {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+	return names
+}
+
+// ValidateParameters checks that every required parameter of the operator
+// has been set on the operation
+func ValidateParameters(operator Operator, op Operation) error {
+	for _, p := range operator.Parameters() {
+		if p.Required && !op.has(p.Name) {
+			return fmt.Errorf("operation %s (%s) is missing required parameter %s", op.Name, op.Type, p.Name)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,85 @@
+package compositor
+
+import (
+	"context"
+	"github.com/mattkimber/gandalf/magica"
+	"testing"
+)
+
+type fillOperator struct{}
+
+func (fillOperator) Parameters() []Parameter {
+	return []Parameter{{Name: "colour", Required: true}}
+}
+
+func (o fillOperator) Validate(op Operation) error {
+	return ValidateParameters(o, op)
+}
+
+func (fillOperator) Apply(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+	r := input.Copy()
+	colour := byte(op.Parameters["colour"].(float64))
+
+	r.Iterate(func(x, y, z int) {
+		if r.Voxels[x][y][z] != 0 {
+			r.Voxels[x][y][z] = colour
+		}
+	})
+
+	return r, nil
+}
+
+func TestRegister(t *testing.T) {
+	if err := Register("scale", fillOperator{}); err == nil {
+		t.Errorf("Expected error when registering a duplicate operator")
+	}
+
+	if err := Register("", fillOperator{}); err == nil {
+		t.Errorf("Expected error when registering an operator with no name")
+	}
+
+	if _, ok := LookupOperator("identity"); !ok {
+		t.Errorf("Built-in operator identity was not registered")
+	}
+}
+
+func TestCustomOperator(t *testing.T) {
+	if err := Register("test_fill", fillOperator{}); err != nil {
+		t.Fatalf("Could not register operator: %v", err)
+	}
+
+	batch := Batch{
+		Files:      []string{"example_input.vox"},
+		Operations: []Operation{{Name: "_fill", Type: "test_fill"}},
+	}
+
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error for missing required parameter")
+	}
+
+	batch.Operations[0].Parameters = map[string]interface{}{"colour": 7.0}
+	outputDirectory := t.TempDir()
+
+	if _, err := batch.Run(context.Background(), outputDirectory, "testdata"); err != nil {
+		t.Fatalf("Could not run batch: %v", err)
+	}
+
+	output, err := magica.FromFile(outputDirectory + "/example_input_fill.vox")
+	if err != nil {
+		t.Fatalf("Could not read output: %v", err)
+	}
+
+	output.Iterate(func(x, y, z int) {
+		if c := output.Voxels[x][y][z]; c != 0 && c != 7 {
+			t.Fatalf("Expected all filled voxels to be colour 7, got %d at (%d,%d,%d)", c, x, y, z)
+		}
+	})
+}
+
+func TestValidateUnknownOperation(t *testing.T) {
+	batch := Batch{Operations: []Operation{{Type: "not_an_operation"}}}
+
+	if err := batch.Validate(); err == nil {
+		t.Errorf("Expected error for unknown operation")
+	}
+}
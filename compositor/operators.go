@@ -0,0 +1,466 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+)
+
+// operatorFunc adapts plain functions to the Operator interface, and is
+// used for all the built-in operations
+type operatorFunc struct {
+	parameters []Parameter
+	validate   func(op Operation) error
+	apply      func(ctx context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error)
+}
+
+func (o operatorFunc) Parameters() []Parameter {
+	return o.parameters
+}
+
+func (o operatorFunc) Validate(op Operation) error {
+	if err := ValidateParameters(o, op); err != nil {
+		return err
+	}
+
+	if o.validate != nil {
+		return o.validate(op)
+	}
+
+	return nil
+}
+
+func (o operatorFunc) Apply(ctx context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+	if err := ctx.Err(); err != nil {
+		return magica.VoxelObject{}, err
+	}
+
+	return o.apply(ctx, input, sources, op)
+}
+
+var (
+	fileParameter       = Parameter{Name: "file", Description: "source voxel object", Required: true}
+	rampParameters      = []Parameter{{Name: "input_ramp"}, {Name: "output_ramp"}, {Name: "input_ramps"}, {Name: "output_ramps"}}
+	maskIndexParameter  = Parameter{Name: "mask_index", Description: "colour index of mask voxels"}
+	transformParameters = []Parameter{{Name: "resampling", Description: "nearest or modal"}, {Name: "samples"}, {Name: "keep_size"}}
+	compositeParameters = []Parameter{{Name: "overwrite"}, {Name: "ignore_mask"}, {Name: "no_mask"}, maskIndexParameter, {Name: "region"}, {Name: "mask_original"}, {Name: "mask_new"}}
+)
+
+func parameters(groups ...[]Parameter) (result []Parameter) {
+	for _, g := range groups {
+		result = append(result, g...)
+	}
+	return result
+}
+
+// validateComposite validates the parameters shared by operations which
+// composite a source object into the cargo area
+func validateComposite(op Operation) error {
+	if err := op.NoMask.validate(); err != nil {
+		return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+	}
+
+	return nil
+}
+
+// rotateOperator returns a single-axis rotation operator with the given
+// default pivot
+func rotateOperator(axis Axis, defaultPivot Pivot) Operator {
+	return operatorFunc{
+		parameters: []Parameter{{Name: "angle"}, {Name: "pivot", Description: "centre or bottom"}},
+		validate: func(op Operation) error {
+			if err := op.Pivot.validate(); err != nil {
+				return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+			}
+			return nil
+		},
+		apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+			pivot := op.Pivot
+			if pivot == "" {
+				pivot = defaultPivot
+			}
+			return RotateAbout(input, axis, op.Angle, pivot)
+		},
+	}
+}
+
+// validateTransform validates the parameters shared by operations which
+// resample an object through an affine transform
+func validateTransform(op Operation) error {
+	if err := op.Resampling.validate(); err != nil {
+		return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+	}
+
+	if op.Samples < 0 {
+		return fmt.Errorf("operation %s (%s): samples %d must be at least 1", op.Name, op.Type, op.Samples)
+	}
+
+	return nil
+}
+
+func init() {
+	builtins := map[string]Operator{
+		"identity": operatorFunc{
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, _ Operation) (magica.VoxelObject, error) {
+				return Identity(input), nil
+			},
+		},
+		"produce_empty": operatorFunc{
+			parameters: parameters(rampParameters, []Parameter{maskIndexParameter}),
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				inputRamps, outputRamps := op.ramps()
+				return ProduceEmpty(input, inputRamps, outputRamps, byte(op.MaskIndex))
+			},
+		},
+		"recolour": operatorFunc{
+			parameters: parameters(rampParameters, []Parameter{
+				{Name: "recolour_mask"},
+				maskIndexParameter,
+				{Name: "recolour_mode"},
+				{Name: "file", Description: "voxel object whose palette is the target for palette mode"},
+			}),
+			validate: func(op Operation) error {
+				if err := op.RecolourMode.validate(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				if len(op.InputColourRamps) != len(op.OutputColourRamps) {
+					return fmt.Errorf("operation %s (%s) has %d input ramps but %d output ramps", op.Name, op.Type, len(op.InputColourRamps), len(op.OutputColourRamps))
+				}
+				if op.RecolourMode == RecolourPalette {
+					if op.File == "" {
+						return fmt.Errorf("operation %s (%s) has no target palette file", op.Name, op.Type)
+					}
+					return nil
+				}
+				if len(op.InputColourRamps) > 0 {
+					return nil
+				}
+				if op.InputColourRamp == "" {
+					return fmt.Errorf("operation %s (%s) has no input ramp", op.Name, op.Type)
+				}
+				if op.OutputColourRamp == "" {
+					return fmt.Errorf("operation %s (%s) has no output ramp", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				opts := op.recolourOptions()
+				if op.RecolourMode == RecolourPalette && len(sources) > 0 {
+					opts.Palette = sources[0].PaletteData
+				}
+				return RecolourObject(input, opts)
+			},
+		},
+		"scale": operatorFunc{
+			parameters: parameters([]Parameter{fileParameter, {Name: "scale"}, {Name: "filter"}, {Name: "priority"}}, rampParameters, compositeParameters),
+			validate: func(op Operation) error {
+				if err := validateComposite(op); err != nil {
+					return err
+				}
+				if err := op.Filter.validate(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				if err := validateScale(op.Scale); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				for _, p := range op.Priority {
+					if p < 1 || p > 255 {
+						return fmt.Errorf("operation %s (%s): priority colour %d is not in the range 1-255", op.Name, op.Type, p)
+					}
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return AddScaled(ctx, input, sources[0], op.scaleOptions())
+			},
+		},
+		"repeat": operatorFunc{
+			parameters: parameters([]Parameter{fileParameter, {Name: "n"}, {Name: "blend_mode"}, {Name: "truncate"}, {Name: "flip_x"}}, rampParameters, compositeParameters),
+			validate:   validateComposite,
+			apply: func(ctx context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return AddRepeated(ctx, input, sources[0], op.repeatOptions())
+			},
+		},
+		"stairstep": operatorFunc{
+			parameters: []Parameter{{Name: "x_steps", Required: true}, {Name: "z_steps", Required: true}},
+			validate: func(op Operation) error {
+				if op.ZSteps < 1 {
+					return fmt.Errorf("operation %s (%s): z_steps must be at least 1", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Stairstep(input, op.XSteps, op.ZSteps)
+			},
+		},
+		"rotate": operatorFunc{
+			parameters: []Parameter{{Name: "angle"}, {Name: "x_offset"}, {Name: "y_offset"}, {Name: "scale"}, {Name: "bounding_volume"}},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return RotateAndTile(input, op.Angle, op.XOffset, op.YOffset, op.Scale, op.BoundingVolume)
+			},
+		},
+		"rotate_axis": operatorFunc{
+			parameters: []Parameter{
+				{Name: "axis", Description: "axis to rotate around, x, y or z", Required: true},
+				{Name: "angle"},
+				{Name: "pivot", Description: "centre or bottom"},
+			},
+			validate: func(op Operation) error {
+				if err := Axis(op.Axis).validate(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				if err := op.Pivot.validate(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				if Axis(op.Axis) == AxisZ && op.Pivot == PivotBottom {
+					return fmt.Errorf("operation %s (%s): bottom pivot cannot be used for rotation around the Z axis", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				pivot := op.Pivot
+				if pivot == "" {
+					pivot = PivotCentre
+				}
+				return RotateAbout(input, Axis(op.Axis), op.Angle, pivot)
+			},
+		},
+		"rotate_x": rotateOperator(AxisX, PivotCentre),
+		"rotate_y": rotateOperator(AxisY, PivotCentre),
+		// rotate_z has always rotated around the X axis from the bottom, and
+		// is kept for existing batches. rotate_axis rotates around Z.
+		"rotate_z": rotateOperator(AxisX, PivotBottom),
+		"rotate_3d": operatorFunc{
+			parameters: parameters([]Parameter{
+				{Name: "euler", Description: "degrees to rotate around the X, Y and Z axes"},
+				{Name: "rotation_axis", Description: "axis for an axis-angle rotation"},
+				{Name: "angle"},
+				{Name: "pivot_point"},
+			}, transformParameters),
+			validate: func(op Operation) error {
+				if err := validateTransform(op); err != nil {
+					return err
+				}
+				if op.has("rotation_axis") {
+					if _, err := RotationMatrix(op.RotationAxis, op.Angle); err != nil {
+						return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+					}
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Rotate3D(ctx, input, op.rotate3DOptions())
+			},
+		},
+		"transform": operatorFunc{
+			parameters: parameters([]Parameter{
+				{Name: "matrix", Description: "3x4 affine transform matrix"},
+				{Name: "steps", Description: "translate, rotate, scale and shear steps"},
+				{Name: "pivot_point"},
+			}, transformParameters),
+			validate: func(op Operation) error {
+				if err := validateTransform(op); err != nil {
+					return err
+				}
+				m, err := op.transform()
+				if err != nil {
+					return err
+				}
+				if _, err := m.Inverse(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				m, err := op.transform()
+				if err != nil {
+					return input, err
+				}
+				return Transform(ctx, input, AroundPivot(m, op.PivotPoint, input.Size), op.transformOptions())
+			},
+		},
+		"shear": operatorFunc{
+			parameters: []Parameter{
+				{Name: "axis", Description: "source axis", Required: true},
+				{Name: "target", Description: "axis to move voxels along", Required: true},
+				{Name: "gradient"},
+				{Name: "smooth"},
+				{Name: "grow"},
+			},
+			validate: func(op Operation) error {
+				if _, err := ShearMatrix(Axis(op.Axis), Axis(op.Target), op.Gradient); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return ShearObject(ctx, input, op.shearOptions())
+			},
+		},
+		"bend": operatorFunc{
+			parameters: []Parameter{
+				{Name: "radius", Description: "radius of the arc in voxels", Required: true},
+				{Name: "target", Description: "axis to curve towards, y or z", Required: true},
+				{Name: "smooth"},
+			},
+			validate: func(op Operation) error {
+				// The radius is checked against the object's size when
+				// the bend is applied, which -check also does
+				if err := op.bendOptions().validate(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Bend(ctx, input, op.bendOptions())
+			},
+		},
+		"rotate_90": operatorFunc{
+			parameters: []Parameter{{Name: "axis", Description: "axis to rotate around", Required: true}, {Name: "turns", Description: "number of quarter turns"}},
+			validate: func(op Operation) error {
+				if err := Axis(op.Axis).validate(); err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return QuarterTurn(input, Axis(op.Axis), op.Turns)
+			},
+		},
+		"swap_axes": operatorFunc{
+			parameters: []Parameter{{Name: "order", Description: "new axis order, e.g. \"yxz\"", Required: true}},
+			validate: func(op Operation) error {
+				order, err := ParseAxes(op.Order)
+				if err == nil {
+					err = validateOrder(order)
+				}
+				if err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				order, err := ParseAxes(op.Order)
+				if err != nil {
+					return input, err
+				}
+				return Permute(input, order)
+			},
+		},
+		"translate": operatorFunc{
+			parameters: []Parameter{{Name: "x_offset"}, {Name: "y_offset"}, {Name: "z_offset"}, {Name: "grow"}, {Name: "wrap"}},
+			validate: func(op Operation) error {
+				if op.Grow && op.Wrap {
+					return fmt.Errorf("operation %s (%s) cannot both grow and wrap", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Translate(input, op.translateOptions())
+			},
+		},
+		"crop": operatorFunc{
+			parameters: []Parameter{{Name: "bounding_volume", Description: "box to crop to, with exclusive maximum"}, {Name: "trim"}},
+			validate: func(op Operation) error {
+				if op.Trim == op.has("bounding_volume") {
+					return fmt.Errorf("operation %s (%s) needs exactly one of bounding_volume or trim", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				if op.Trim {
+					return Trim(input)
+				}
+				return Crop(input, op.BoundingVolume)
+			},
+		},
+		"pad": operatorFunc{
+			parameters: []Parameter{{Name: "size", Description: "new canvas size", Required: true}, {Name: "anchor"}},
+			validate: func(op Operation) error {
+				for _, a := range []Anchor{op.Anchor.X, op.Anchor.Y, op.Anchor.Z} {
+					if err := a.validate(); err != nil {
+						return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+					}
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Pad(input, PadOptions{Size: op.Size, Anchor: op.Anchor})
+			},
+		},
+		"resize": operatorFunc{
+			parameters: []Parameter{{Name: "scale", Description: "factor to resize by along each axis", Required: true}, {Name: "smooth"}, maskIndexParameter},
+			validate: func(op Operation) error {
+				for _, f := range []float64{op.Scale.X, op.Scale.Y, op.Scale.Z} {
+					if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+						return fmt.Errorf("operation %s (%s): resize scale %g is not valid", op.Name, op.Type, f)
+					}
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Resize(ctx, input, op.resizeOptions())
+			},
+		},
+		"mirror": operatorFunc{
+			parameters: []Parameter{
+				{Name: "axis", Description: "axes to mirror along, e.g. \"xz\", or a single axis when symmetrizing", Required: true},
+				{Name: "symmetrize"},
+				{Name: "plane"},
+				{Name: "from_max"},
+			},
+			validate: func(op Operation) error {
+				axes, err := ParseAxes(op.Axis)
+				if err != nil {
+					return fmt.Errorf("operation %s (%s): %w", op.Name, op.Type, err)
+				}
+				if op.Symmetrize && len(axes) != 1 {
+					return fmt.Errorf("operation %s (%s): symmetrize needs exactly one axis", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(_ context.Context, input magica.VoxelObject, _ []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				if op.Symmetrize {
+					return Symmetrize(input, op.symmetrizeOptions())
+				}
+
+				axes, err := ParseAxes(op.Axis)
+				if err != nil {
+					return input, err
+				}
+				return Mirror(input, axes...)
+			},
+		},
+		"remove": operatorFunc{
+			parameters: []Parameter{fileParameter},
+			apply: func(_ context.Context, input magica.VoxelObject, sources []magica.VoxelObject, _ Operation) (magica.VoxelObject, error) {
+				return Remove(input, sources[0], 0), nil
+			},
+		},
+		"clip": operatorFunc{
+			parameters: []Parameter{fileParameter, maskIndexParameter},
+			apply: func(_ context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Remove(input, sources[0], maskOrDefault(byte(op.MaskIndex))), nil
+			},
+		},
+		"exec": operatorFunc{
+			parameters: []Parameter{{Name: "command", Required: true}, {Name: "timeout"}, {Name: "file"}},
+			validate: func(op Operation) error {
+				if op.Timeout < 0 {
+					return fmt.Errorf("operation %s (%s) has a negative timeout", op.Name, op.Type)
+				}
+				return nil
+			},
+			apply: func(ctx context.Context, input magica.VoxelObject, sources []magica.VoxelObject, op Operation) (magica.VoxelObject, error) {
+				return Exec(ctx, input, sources, op.execOptions())
+			},
+		},
+	}
+
+	for name, operator := range builtins {
+		if err := Register(name, operator); err != nil {
+			panic(err)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package compositor
+
+import (
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+)
+
+// planeAxes returns the axes of the plane perpendicular to axis, in the
+// order used for rotations
+func planeAxes(axis Axis) (a, b Axis, err error) {
+	switch axis {
+	case AxisX:
+		return AxisY, AxisZ, nil
+	case AxisY:
+		return AxisX, AxisZ, nil
+	case AxisZ:
+		return AxisX, AxisY, nil
+	}
+
+	return a, b, axis.validate()
+}
+
+// QuarterTurn rotates an object around the given axis by a whole number of
+// quarter turns, in the same direction as RotateAbout with positive angles.
+// Negative turns rotate the other way. No resampling is done, so every
+// voxel is kept exactly, and the canvas is resized to fit.
+func QuarterTurn(v magica.VoxelObject, axis Axis, turns int) (r magica.VoxelObject, err error) {
+	a, b, err := planeAxes(axis)
+	if err != nil {
+		return v.Copy(), err
+	}
+
+	r = v.Copy()
+	for i := 0; i < ((turns%4)+4)%4; i++ {
+		r = quarterTurn(r, a, b)
+	}
+
+	return r, nil
+}
+
+// quarterTurn rotates an object a single quarter turn in the plane of axes
+// a and b
+func quarterTurn(v magica.VoxelObject, a, b Axis) (r magica.VoxelObject) {
+	size := v.Size
+	a.set(&size, b.of(v.Size))
+	b.set(&size, a.of(v.Size))
+
+	r = magica.NewVoxelObject(size, v.PaletteData)
+
+	v.Iterate(func(x, y, z int) {
+		src := geometry.Point{X: x, Y: y, Z: z}
+		dst := src
+		a.set(&dst, b.of(v.Size)-1-b.of(src))
+		b.set(&dst, a.of(src))
+		r.Voxels[dst.X][dst.Y][dst.Z] = v.Voxels[x][y][z]
+	})
+
+	return r
+}
+
+// Permute reorders the axes of an object. Each axis of the output is taken
+// from the corresponding input axis in order, so {AxisY, AxisX, AxisZ}
+// swaps X and Y. Every voxel is kept exactly, and the canvas is resized to
+// fit.
+func Permute(v magica.VoxelObject, order []Axis) (r magica.VoxelObject, err error) {
+	if err := validateOrder(order); err != nil {
+		return v.Copy(), err
+	}
+
+	outputAxes := []Axis{AxisX, AxisY, AxisZ}
+
+	size := geometry.Point{}
+	for i, a := range order {
+		outputAxes[i].set(&size, a.of(v.Size))
+	}
+
+	r = magica.NewVoxelObject(size, v.PaletteData)
+
+	r.Iterate(func(x, y, z int) {
+		dst := geometry.Point{X: x, Y: y, Z: z}
+		src := geometry.Point{}
+		for i, a := range order {
+			a.set(&src, outputAxes[i].of(dst))
+		}
+		r.Voxels[x][y][z] = v.Voxels[src.X][src.Y][src.Z]
+	})
+
+	return r, nil
+}
+
+// validateOrder checks that an axis order contains each axis exactly once
+func validateOrder(order []Axis) error {
+	if len(order) != 3 {
+		return fmt.Errorf("axis order must have 3 axes, not %d", len(order))
+	}
+
+	seen := map[Axis]bool{}
+	for _, a := range order {
+		if err := a.validate(); err != nil {
+			return err
+		}
+		if seen[a] {
+			return fmt.Errorf("axis %s appears more than once in axis order", a)
+		}
+		seen[a] = true
+	}
+
+	return nil
+}
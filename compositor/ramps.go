@@ -0,0 +1,164 @@
+package compositor
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// rampFile is the format of files included by a batch's include list
+type rampFile struct {
+	Ramps map[string]string `json:"ramps"`
+}
+
+// loadIncludes merges the ramps from each included file into the batch.
+// Relative paths are resolved from directory. Later files take precedence
+// over earlier ones, and the batch's own ramps take precedence over all of
+// them.
+func (b *Batch) loadIncludes(directory string) error {
+	if len(b.Include) == 0 {
+		return nil
+	}
+
+	ramps := map[string]string{}
+
+	for _, include := range b.Include {
+		filename := include
+		if !filepath.IsAbs(filename) {
+			filename = filepath.Join(directory, filename)
+		}
+
+		data, err := os.ReadFile(filename)
+		if err != nil {
+			return fmt.Errorf("could not read included file %s: %w", filename, err)
+		}
+
+		var rf rampFile
+		if err := json.Unmarshal(data, &rf); err != nil {
+			return fmt.Errorf("could not parse included file %s: %w", filename, err)
+		}
+
+		for name, ramp := range rf.Ramps {
+			ramps[name] = ramp
+		}
+	}
+
+	for name, ramp := range b.Ramps {
+		ramps[name] = ramp
+	}
+
+	b.Ramps = ramps
+	return nil
+}
+
+// resolveRamp replaces any @name references in a comma-separated ramp
+// specification with the named ramps from the batch
+func (b *Batch) resolveRamp(ramp string) (string, error) {
+	if !strings.Contains(ramp, "@") {
+		return ramp, nil
+	}
+
+	tokens := strings.Split(ramp, ",")
+	for i, token := range tokens {
+		token = strings.TrimSpace(token)
+		if !strings.HasPrefix(token, "@") {
+			continue
+		}
+
+		named, ok := b.Ramps[token[1:]]
+		if !ok {
+			return "", fmt.Errorf("unknown named ramp %s", token)
+		}
+		tokens[i] = named
+	}
+
+	return strings.Join(tokens, ","), nil
+}
+
+// resolveRamps replaces named ramp references in all of the operation's
+// ramps
+func (b *Batch) resolveRamps(op *Operation) (err error) {
+	if op.InputColourRamp, err = b.resolveRamp(op.InputColourRamp); err != nil {
+		return err
+	}
+
+	if op.OutputColourRamp, err = b.resolveRamp(op.OutputColourRamp); err != nil {
+		return err
+	}
+
+	if op.InputColourRamps, err = b.resolveRampList(op.InputColourRamps); err != nil {
+		return err
+	}
+
+	op.OutputColourRamps, err = b.resolveRampList(op.OutputColourRamps)
+	return err
+}
+
+// resolveRampList returns a copy of ramps with named ramp references
+// replaced, leaving the original slice untouched
+func (b *Batch) resolveRampList(ramps []string) ([]string, error) {
+	if ramps == nil {
+		return nil, nil
+	}
+
+	resolved := make([]string, len(ramps))
+	for i, ramp := range ramps {
+		var err error
+		if resolved[i], err = b.resolveRamp(ramp); err != nil {
+			return nil, err
+		}
+	}
+
+	return resolved, nil
+}
+
+// namedRamps returns the names of every ramp referenced by the operation
+func namedRamps(op Operation) (names []string) {
+	ramps := append([]string{op.InputColourRamp, op.OutputColourRamp}, op.InputColourRamps...)
+	ramps = append(ramps, op.OutputColourRamps...)
+
+	for _, ramp := range ramps {
+		for _, token := range strings.Split(ramp, ",") {
+			token = strings.TrimSpace(token)
+			if strings.HasPrefix(token, "@") {
+				names = append(names, token[1:])
+			}
+		}
+	}
+
+	for _, region := range op.Regions {
+		names = append(names, namedRamps(region)...)
+	}
+
+	return names
+}
+
+// RampUsage returns the names of the operations which use each named ramp.
+// Every ramp in the batch is included, even if no operations use it, as are
+// any unknown names which operations refer to.
+func (b *Batch) RampUsage() map[string][]string {
+	usage := map[string][]string{}
+
+	for name := range b.Ramps {
+		usage[name] = nil
+	}
+
+	for _, op := range b.Operations {
+		seen := map[string]bool{}
+		for _, name := range namedRamps(op) {
+			if !seen[name] {
+				usage[name] = append(usage[name], op.Name)
+				seen[name] = true
+			}
+		}
+	}
+
+	for name := range usage {
+		sort.Strings(usage[name])
+	}
+
+	return usage
+}
@@ -0,0 +1,267 @@
+package compositor
+
+import (
+	"fmt"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+)
+
+// RecolourMode selects how RecolourObject chooses output colours
+type RecolourMode string
+
+const (
+	// RecolourIndex maps colour indices linearly from the input ramp to the
+	// output ramp, as Recolour does
+	RecolourIndex RecolourMode = "index"
+	// RecolourLuminance maps each input colour to the output ramp colour
+	// whose luminance is closest to the input colour's relative position
+	// within the luminance range of its ramp
+	RecolourLuminance RecolourMode = "luminance"
+	// RecolourPalette maps every colour to the nearest RGB colour in a
+	// target palette, restricted to the output ramp for colours in an input
+	// ramp
+	RecolourPalette RecolourMode = "palette"
+)
+
+func (m RecolourMode) validate() error {
+	switch m {
+	case "", RecolourIndex, RecolourLuminance, RecolourPalette:
+		return nil
+	}
+
+	return fmt.Errorf("unknown recolour mode %s", m)
+}
+
+// RecolourOptions controls how RecolourObject recolours an object
+type RecolourOptions struct {
+	// InputRamps and OutputRamps are pairs of ramps in the same format as
+	// Recolour. Each voxel is recoloured by the first pair which matches it.
+	InputRamps  []string
+	OutputRamps []string
+
+	// IncludeMask allows mask voxels to be recoloured. By default they are
+	// left unchanged.
+	IncludeMask bool
+
+	// MaskIndex is the colour index of mask voxels. 0 uses DefaultMaskIndex.
+	MaskIndex byte
+
+	// Mode selects how output colours are chosen. The default is
+	// RecolourIndex.
+	Mode RecolourMode
+
+	// Palette is the target palette for RecolourPalette, in the same RGBA
+	// format as VoxelObject.PaletteData. The result uses this palette.
+	Palette []byte
+}
+
+// RecolourObject recolours an object according to one or more pairs of
+// input/output ramps, leaving mask voxels alone unless requested
+func RecolourObject(v magica.VoxelObject, opts RecolourOptions) (r magica.VoxelObject, err error) {
+	r = v.Copy()
+
+	if len(opts.InputRamps) != len(opts.OutputRamps) {
+		return r, fmt.Errorf("%d input ramps but %d output ramps", len(opts.InputRamps), len(opts.OutputRamps))
+	}
+
+	if err := opts.Mode.validate(); err != nil {
+		return r, err
+	}
+
+	if opts.Mode == RecolourLuminance || opts.Mode == RecolourPalette {
+		return recolourByPalette(r, opts)
+	}
+
+	mask := maskOrDefault(opts.MaskIndex)
+	changed := make([][][]bool, v.Size.X)
+	for x := range changed {
+		changed[x] = make([][]bool, v.Size.Y)
+		for y := range changed[x] {
+			changed[x][y] = make([]bool, v.Size.Z)
+		}
+	}
+
+	for idx := range opts.InputRamps {
+		recoloured, err := Recolour(v, opts.InputRamps[idx], opts.OutputRamps[idx])
+		if err != nil {
+			return r, err
+		}
+
+		r.Iterate(func(x, y, z int) {
+			c := v.Voxels[x][y][z]
+			if changed[x][y][z] || (c == mask && !opts.IncludeMask) {
+				return
+			}
+
+			if recoloured.Voxels[x][y][z] != c {
+				r.Voxels[x][y][z] = recoloured.Voxels[x][y][z]
+				changed[x][y][z] = true
+			}
+		})
+	}
+
+	return r, nil
+}
+
+// paletteSize is the minimum length of palette data covering colours 1-255
+const paletteSize = 255 * 4
+
+// rgb returns the colour of index c in palette
+func rgb(palette []byte, c byte) (r, g, b float64) {
+	i := (int(c) - 1) * 4
+	return float64(palette[i]), float64(palette[i+1]), float64(palette[i+2])
+}
+
+// luminance returns the relative luminance of index c in palette
+func luminance(palette []byte, c byte) float64 {
+	linear := func(v float64) float64 {
+		v /= 255
+		if v <= 0.04045 {
+			return v / 12.92
+		}
+		return math.Pow((v+0.055)/1.055, 2.4)
+	}
+
+	r, g, b := rgb(palette, c)
+	return 0.2126*linear(r) + 0.7152*linear(g) + 0.0722*linear(b)
+}
+
+// distance returns the squared RGB distance between index a in one palette
+// and index b in another
+func distance(paletteA []byte, a byte, paletteB []byte, b byte) float64 {
+	ra, ga, ba := rgb(paletteA, a)
+	rb, gb, bb := rgb(paletteB, b)
+	return (ra-rb)*(ra-rb) + (ga-gb)*(ga-gb) + (ba-bb)*(ba-bb)
+}
+
+// outputs returns the colour indices of the ramp's output, in ramp order
+func (rmp Ramp) outputs() (result []byte) {
+	step := 1
+	if rmp.OutputLength < 0 {
+		step = -1
+	}
+
+	for c := rmp.OutputStartIndex; ; c += step {
+		if c > 0 {
+			result = append(result, byte(c))
+		}
+		if c == rmp.OutputStartIndex+int(rmp.OutputLength) {
+			return result
+		}
+	}
+}
+
+// nearest returns the candidate with the smallest score, with ties going to
+// the earliest candidate
+func nearest(candidates []byte, score func(c byte) float64) byte {
+	best, bestScore := byte(0), math.Inf(1)
+	for _, c := range candidates {
+		if s := score(c); s < bestScore {
+			best, bestScore = c, s
+		}
+	}
+	return best
+}
+
+// recolourByPalette implements the luminance and palette modes of
+// RecolourObject by building a map from each source colour to its output
+func recolourByPalette(r magica.VoxelObject, opts RecolourOptions) (magica.VoxelObject, error) {
+	if len(r.PaletteData) < paletteSize {
+		return r, fmt.Errorf("object has no palette")
+	}
+
+	if opts.Mode == RecolourPalette && len(opts.Palette) < paletteSize {
+		return r, fmt.Errorf("no target palette for palette recolouring")
+	}
+
+	mask := maskOrDefault(opts.MaskIndex)
+	var colourMap [256]byte
+	var mapped [256]bool
+
+	for idx := range opts.InputRamps {
+		ramps, err := parseRamps(opts.InputRamps[idx], opts.OutputRamps[idx])
+		if err != nil {
+			return r, err
+		}
+
+		for _, rmp := range ramps {
+			outputs := rmp.outputs()
+			if len(outputs) == 0 {
+				continue
+			}
+
+			if opts.Mode == RecolourPalette {
+				for c := rmp.StartIndex; c <= rmp.EndIndex; c++ {
+					if c == 0 || mapped[c] {
+						continue
+					}
+					colourMap[c] = nearest(outputs, func(o byte) float64 { return distance(r.PaletteData, byte(c), opts.Palette, o) })
+					mapped[c] = true
+				}
+				continue
+			}
+
+			inMin, inMax := math.Inf(1), math.Inf(-1)
+			for c := rmp.StartIndex; c <= rmp.EndIndex; c++ {
+				if c > 0 {
+					l := luminance(r.PaletteData, byte(c))
+					inMin, inMax = math.Min(inMin, l), math.Max(inMax, l)
+				}
+			}
+
+			outMin, outMax := math.Inf(1), math.Inf(-1)
+			for _, o := range outputs {
+				l := luminance(r.PaletteData, o)
+				outMin, outMax = math.Min(outMin, l), math.Max(outMax, l)
+			}
+
+			for c := rmp.StartIndex; c <= rmp.EndIndex; c++ {
+				if c == 0 || mapped[c] {
+					continue
+				}
+
+				// A ramp with no variation in luminance maps to the middle
+				// of the output ramp
+				position := 0.5
+				if inMax > inMin {
+					position = (luminance(r.PaletteData, byte(c)) - inMin) / (inMax - inMin)
+				}
+
+				target := outMin + position*(outMax-outMin)
+				colourMap[c] = nearest(outputs, func(o byte) float64 { return math.Abs(luminance(r.PaletteData, o) - target) })
+				mapped[c] = true
+			}
+		}
+	}
+
+	// In palette mode every remaining colour is mapped to the nearest colour
+	// in the whole target palette, other than the mask colour
+	if opts.Mode == RecolourPalette {
+		candidates := make([]byte, 0, 255)
+		for c := 1; c < 256; c++ {
+			if byte(c) != mask || opts.IncludeMask {
+				candidates = append(candidates, byte(c))
+			}
+		}
+
+		for c := 1; c < 256; c++ {
+			if !mapped[c] {
+				colourMap[c] = nearest(candidates, func(o byte) float64 { return distance(r.PaletteData, byte(c), opts.Palette, o) })
+				mapped[c] = true
+			}
+		}
+	}
+
+	r.Iterate(func(x, y, z int) {
+		c := r.Voxels[x][y][z]
+		if mapped[c] && (c != mask || opts.IncludeMask) {
+			r.Voxels[x][y][z] = colourMap[c]
+		}
+	})
+
+	if opts.Mode == RecolourPalette {
+		r.PaletteData = append([]byte(nil), opts.Palette...)
+	}
+
+	return r, nil
+}
@@ -0,0 +1,259 @@
+package compositor
+
+import (
+	"context"
+	"fmt"
+	"github.com/mattkimber/gandalf/geometry"
+	"github.com/mattkimber/gandalf/magica"
+	"math"
+)
+
+// ResizeOptions controls how Resize scales an object
+type ResizeOptions struct {
+	// Scale is the factor to resize by along each axis. Axes with a scale
+	// of 0 are left unchanged.
+	Scale geometry.PointF
+
+	// Smooth rounds off stair-stepped edges when enlarging, rather than
+	// just repeating each voxel
+	Smooth bool
+
+	// MaskIndex is the colour index of mask voxels. 0 uses DefaultMaskIndex.
+	MaskIndex byte
+}
+
+// Resize scales a whole object. When shrinking, each output voxel takes the
+// most common colour of the input voxels it covers, with ties going to the
+// lowest index and empty space only winning if it is more common than every
+// colour. Mask voxels take part in the vote like any other colour, but each
+// separate mask region keeps at least one voxel so that no cargo area is
+// lost. When enlarging, each output voxel takes the colour of the input
+// voxel at its centre.
+func Resize(ctx context.Context, v magica.VoxelObject, opts ResizeOptions) (r magica.VoxelObject, err error) {
+	if err := checkSize(&v, "input"); err != nil {
+		return v.Copy(), err
+	}
+
+	axes := []Axis{AxisX, AxisY, AxisZ}
+	factors := []float64{opts.Scale.X, opts.Scale.Y, opts.Scale.Z}
+	size := v.Size
+
+	for i, a := range axes {
+		f := factors[i]
+		if f == 0 {
+			continue
+		}
+
+		if f < 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+			return v.Copy(), fmt.Errorf("resize scale %g for %s axis is not valid", f, a)
+		}
+
+		s := int(math.Max(1, math.Round(float64(a.of(v.Size))*f)))
+		if s > maxTransformSize {
+			return v.Copy(), fmt.Errorf("resized object would be %d voxels across, which is more than %d", s, maxTransformSize)
+		}
+		a.set(&size, s)
+	}
+
+	mask := maskOrDefault(opts.MaskIndex)
+	r = magica.NewVoxelObject(size, v.PaletteData)
+
+	// Use the actual ratio between sizes, so that every input voxel is
+	// covered exactly
+	ratio := func(a Axis) float64 { return float64(a.of(v.Size)) / float64(a.of(size)) }
+	rx, ry, rz := ratio(AxisX), ratio(AxisY), ratio(AxisZ)
+
+	err = iterate(ctx, &r, func(x, y, z int) {
+		minX, maxX := sourceRange(x, rx)
+		minY, maxY := sourceRange(y, ry)
+		minZ, maxZ := sourceRange(z, rz)
+
+		var counts colourCounts
+		empty := 0
+
+		for sx := minX; sx < maxX; sx++ {
+			for sy := minY; sy < maxY; sy++ {
+				for sz := minZ; sz < maxZ; sz++ {
+					// Empty space is counted separately, so it only
+					// competes with the winning colour
+					if c := v.Voxels[sx][sy][sz]; c == 0 {
+						empty++
+					} else {
+						counts[c]++
+					}
+				}
+			}
+		}
+
+		best := counts.pick(FilterModal, nil, nil)
+		if counts[best] >= empty {
+			r.Voxels[x][y][z] = best
+		}
+	})
+	if err != nil {
+		return r, err
+	}
+
+	keepMaskRegions(&v, &r, rx, ry, rz, mask)
+
+	if opts.Smooth {
+		return smoothEdges(ctx, &v, r, rx, ry, rz, mask)
+	}
+
+	return r, nil
+}
+
+// sourceRange returns the input voxels covered by output voxel i, where
+// each output voxel is ratio input voxels across. When enlarging this is
+// the single input voxel at the centre of the output voxel.
+func sourceRange(i int, ratio float64) (int, int) {
+	if ratio <= 1 {
+		c := int(math.Floor((float64(i) + 0.5) * ratio))
+		return c, c + 1
+	}
+
+	return int(math.Floor(float64(i)*ratio + 1e-9)), int(math.Ceil(float64(i+1)*ratio - 1e-9))
+}
+
+// keepMaskRegions makes sure that every connected region of mask voxels in
+// the input still has at least one mask voxel in the resized output. Regions
+// which lost the vote everywhere get a single mask voxel, in the output voxel
+// which covers most of the region.
+func keepMaskRegions(v *magica.VoxelObject, r *magica.VoxelObject, rx, ry, rz float64, mask byte) {
+	labels, regions := labelMask(v, mask)
+	if len(regions) == 0 {
+		return
+	}
+
+	kept := make([]bool, len(regions))
+	covered := make([]map[geometry.Point]int, len(regions))
+	for i := range covered {
+		covered[i] = map[geometry.Point]int{}
+	}
+
+	v.Iterate(func(x, y, z int) {
+		label := labels[x][y][z]
+		if label == 0 {
+			return
+		}
+
+		p := geometry.Point{X: targetIndex(x, rx, r.Size.X), Y: targetIndex(y, ry, r.Size.Y), Z: targetIndex(z, rz, r.Size.Z)}
+		if r.Voxels[p.X][p.Y][p.Z] == mask {
+			kept[label-1] = true
+		}
+		covered[label-1][p]++
+	})
+
+	for i, points := range covered {
+		if kept[i] {
+			continue
+		}
+
+		// Break ties by scan order, so the result doesn't depend on the
+		// order of map iteration
+		var best geometry.Point
+		bestCount := 0
+		for p, n := range points {
+			if n > bestCount || (n == bestCount && before(p, best)) {
+				best, bestCount = p, n
+			}
+		}
+
+		r.Voxels[best.X][best.Y][best.Z] = mask
+	}
+}
+
+// targetIndex returns the output voxel covering input voxel i, where each
+// output voxel is ratio input voxels across
+func targetIndex(i int, ratio float64, size int) int {
+	return min(int(math.Floor((float64(i)+0.5)/ratio)), size-1)
+}
+
+// before reports whether a comes before b when scanning in x, then y, then z
+func before(a, b geometry.Point) bool {
+	if a.X != b.X {
+		return a.X < b.X
+	}
+	if a.Y != b.Y {
+		return a.Y < b.Y
+	}
+	return a.Z < b.Z
+}
+
+// smoothEdges rounds off stair-stepped edges in an enlarged object, in the
+// same way as the EPX pixel art scaler. Each output voxel looks at the
+// input voxels next to the one it came from, on the side it is closest to
+// along each enlarged axis. If two of them agree on a colour which differs
+// from the original voxel, and the input voxels on the opposite sides
+// don't have that colour, the output voxel takes it. This cuts off convex
+// corners and fills in concave ones, while flat faces, thin details and
+// single voxels are kept. Mask voxels are never changed, and other voxels
+// never become mask, so the cargo area keeps its shape.
+func smoothEdges(ctx context.Context, v *magica.VoxelObject, r magica.VoxelObject, rx, ry, rz float64, mask byte) (magica.VoxelObject, error) {
+	result := r.Copy()
+	ratios := []float64{rx, ry, rz}
+
+	at := func(p geometry.Point) byte {
+		if p.X < 0 || p.Y < 0 || p.Z < 0 || p.X >= v.Size.X || p.Y >= v.Size.Y || p.Z >= v.Size.Z {
+			return 0
+		}
+		return v.Voxels[p.X][p.Y][p.Z]
+	}
+
+	err := iterate(ctx, &r, func(x, y, z int) {
+		out := []int{x, y, z}
+		src := geometry.Point{}
+		var near, far []geometry.Point
+
+		for i, a := range []Axis{AxisX, AxisY, AxisZ} {
+			centre := (float64(out[i]) + 0.5) * ratios[i]
+			c := int(math.Floor(centre))
+			a.set(&src, c)
+
+			if ratios[i] >= 1 {
+				continue
+			}
+
+			// Offset of the output voxel from the centre of its input voxel
+			offset := centre - (float64(c) + 0.5)
+			if math.Abs(offset) < 1e-9 {
+				continue
+			}
+
+			step := 1
+			if offset < 0 {
+				step = -1
+			}
+
+			n, f := geometry.Point{}, geometry.Point{}
+			a.set(&n, step)
+			a.set(&f, -step)
+			near, far = append(near, n), append(far, f)
+		}
+
+		original := at(src)
+		if original == mask {
+			return
+		}
+
+		offsetBy := func(d geometry.Point) geometry.Point {
+			return geometry.Point{X: src.X + d.X, Y: src.Y + d.Y, Z: src.Z + d.Z}
+		}
+
+		for i := 0; i < len(near); i++ {
+			for j := i + 1; j < len(near); j++ {
+				k := at(offsetBy(near[i]))
+				if k == original || k == mask || k != at(offsetBy(near[j])) {
+					continue
+				}
+
+				if at(offsetBy(far[i])) != k && at(offsetBy(far[j])) != k {
+					result.Voxels[x][y][z] = k
+					return
+				}
+			}
+		}
+	})
+
+	return result, err
+}
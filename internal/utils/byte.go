@@ -25,6 +25,7 @@ func CompareToFile(data []byte, filename string) (bool, error) {
 	if err != nil {
 		return false, err
 	}
+	defer handle.Close()
 
 	expected, err := ioutil.ReadAll(handle)
 	if err != nil {